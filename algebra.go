@@ -0,0 +1,280 @@
+package zset
+
+import "sort"
+
+/*-----------------------------------------------------------------------------
+ * Union, intersection and difference, ZUNIONSTORE / ZINTERSTORE / ZDIFFSTORE
+ *----------------------------------------------------------------------------*/
+
+// Aggregate tells Union and Inter how to combine the scores of an element
+// found in more than one set.
+type Aggregate uint8
+
+// AGGREGATE options of ZUNIONSTORE and ZINTERSTORE.
+const (
+	AggregateSum Aggregate = iota
+	AggregateMin
+	AggregateMax
+)
+
+func aggregate[S Number](agg Aggregate, target *S, val S) {
+	switch agg {
+	case AggregateSum:
+		*target = *target + val
+		/* The result of adding two doubles is NaN when one variable
+		 * is +inf and the other is -inf. When these numbers are added,
+		 * we maintain the convention of the result being 0.0. */
+		if isNaN(*target) {
+			*target = 0
+		}
+	case AggregateMin:
+		if val < *target {
+			*target = val
+		}
+	case AggregateMax:
+		if val > *target {
+			*target = val
+		}
+	}
+}
+
+func weightAt[S Number](weights []S, i int) S {
+	if weights == nil {
+		return 1
+	}
+	if len(weights) <= i {
+		panic("zset: fewer weights than sets")
+	}
+	return weights[i]
+}
+
+func weighted[S Number](score, weight S) S {
+	value := score * weight
+	/* 0 * inf is NaN, Redis keeps it as 0. */
+	if isNaN(value) {
+		return 0
+	}
+	return value
+}
+
+// Union implements ZUNION. The score of every element is multiplied by the
+// weight of its set, nil weights meaning 1 for all the sets, and the scores
+// of an element in several sets are combined as agg says.
+// The result orders keys like the first set, so sets can not be empty.
+func Union[K comparable, S Number](weights []S, agg Aggregate, sets ...*SortedSet[K, S]) *SortedSet[K, S] {
+	mustHaveSets(sets)
+	return fromDict(sets[0], union(weights, agg, sets))
+}
+
+// Inter implements ZINTER, weights and agg work like in Union.
+func Inter[K comparable, S Number](weights []S, agg Aggregate, sets ...*SortedSet[K, S]) *SortedSet[K, S] {
+	mustHaveSets(sets)
+	return fromDict(sets[0], inter(weights, agg, sets))
+}
+
+// Diff implements ZDIFF, it returns the elements of the first set which
+// are not in any of the others, with their scores.
+func Diff[K comparable, S any](sets ...*SortedSet[K, S]) *SortedSet[K, S] {
+	mustHaveSets(sets)
+	return fromDict(sets[0], diff(sets))
+}
+
+/* union, inter and diff return the elements of the result, reading all the
+ * sets at the same time so that the result is consistent. */
+
+func union[K comparable, S Number](weights []S, agg Aggregate, sets []*SortedSet[K, S]) map[K]S {
+	defer rlockAll(sets)()
+	acc := make(map[K]S)
+	for i, z := range sets {
+		weight := weightAt(weights, i)
+		for key, score := range z.dict {
+			value := weighted(score, weight)
+			if cur, ok := acc[key]; ok {
+				aggregate(agg, &cur, value)
+				acc[key] = cur
+			} else {
+				acc[key] = value
+			}
+		}
+	}
+	return acc
+}
+
+func inter[K comparable, S Number](weights []S, agg Aggregate, sets []*SortedSet[K, S]) map[K]S {
+	defer rlockAll(sets)()
+	/* Start from the smallest set, to check as few elements as possible. */
+	order := bySize(sets)
+	first := order[0]
+	acc := make(map[K]S)
+	for key, score := range sets[first].dict {
+		acc[key] = weighted(score, weightAt(weights, first))
+	}
+
+	for _, i := range order[1:] {
+		weight := weightAt(weights, i)
+		z := sets[i]
+		for key, cur := range acc {
+			score, ok := z.dict[key]
+			if !ok {
+				delete(acc, key)
+				continue
+			}
+			aggregate(agg, &cur, weighted(score, weight))
+			acc[key] = cur
+		}
+	}
+	return acc
+}
+
+func diff[K comparable, S any](sets []*SortedSet[K, S]) map[K]S {
+	defer rlockAll(sets)()
+	acc := make(map[K]S)
+	for key, score := range sets[0].dict {
+		acc[key] = score
+	}
+
+	for _, z := range sets[1:] {
+		for key := range acc {
+			if _, ok := z.dict[key]; ok {
+				delete(acc, key)
+			}
+		}
+	}
+	return acc
+}
+
+// InterCard implements ZINTERCARD. A positive limit stops counting
+// as soon as it is reached. The sets are read at the same time, so the
+// count is consistent.
+func InterCard[K comparable, S any](limit int64, sets ...*SortedSet[K, S]) int64 {
+	if len(sets) == 0 {
+		return 0
+	}
+	defer rlockAll(sets)()
+	/* Iterate the smallest set, looking up its keys in the others. */
+	smallest := sets[0]
+	for _, z := range sets[1:] {
+		if z.zsl.length < smallest.zsl.length {
+			smallest = z
+		}
+	}
+	var card int64
+	for key := range smallest.dict {
+		found := true
+		for _, z := range sets {
+			if z == smallest {
+				continue
+			}
+			if _, found = z.dict[key]; !found {
+				break
+			}
+		}
+		if found {
+			card++
+			if card == limit {
+				break
+			}
+		}
+	}
+	return card
+}
+
+// UnionStore implements ZUNIONSTORE, it replaces the content of dst with
+// the Union of sets and returns its length. dst may be one of the sets,
+// the result is ordered like dst.
+func UnionStore[K comparable, S Number](dst *SortedSet[K, S], weights []S, agg Aggregate, sets ...*SortedSet[K, S]) int64 {
+	mustHaveSets(sets)
+	return dst.store(fromDict(dst, union(weights, agg, sets)))
+}
+
+// InterStore implements ZINTERSTORE, it replaces the content of dst with
+// the Inter of sets and returns its length. dst may be one of the sets,
+// the result is ordered like dst.
+func InterStore[K comparable, S Number](dst *SortedSet[K, S], weights []S, agg Aggregate, sets ...*SortedSet[K, S]) int64 {
+	mustHaveSets(sets)
+	return dst.store(fromDict(dst, inter(weights, agg, sets)))
+}
+
+// DiffStore implements ZDIFFSTORE, it replaces the content of dst with
+// the Diff of sets and returns its length. dst may be one of the sets,
+// the result is ordered like dst.
+func DiffStore[K comparable, S any](dst *SortedSet[K, S], sets ...*SortedSet[K, S]) int64 {
+	mustHaveSets(sets)
+	return dst.store(fromDict(dst, diff(sets)))
+}
+
+func (z *SortedSet[K, S]) store(src *SortedSet[K, S]) int64 {
+	z.lock.Lock()
+	defer z.lock.Unlock()
+	if z.onRemove != nil {
+		for key := range z.dict {
+			if _, ok := src.dict[key]; !ok {
+				z.onRemove(key)
+			}
+		}
+	}
+	z.dict = src.dict
+	z.zsl = src.zsl
+	z.moves.forget()
+	z.journalStore()
+	z.serveBlocked()
+	return z.zsl.length
+}
+
+// mustHaveSets panics when there are no input sets, as there would be
+// no way to tell how the result orders keys.
+func mustHaveSets[K comparable, S any](sets []*SortedSet[K, S]) {
+	if len(sets) == 0 {
+		panic("zset: at least 1 input set is needed")
+	}
+}
+
+// fromDict returns a SortedSet with the elements of dict, ordering scores
+// and keys like the set like does.
+func fromDict[K comparable, S any](like *SortedSet[K, S], dict map[K]S) *SortedSet[K, S] {
+	z := like.empty()
+	z.dict = dict
+	for key, score := range dict {
+		z.zsl.zslInsert(score, key)
+	}
+	return z
+}
+
+/* Read-locks the distinct sets in id order, like transactions lock them,
+ * and returns the function unlocking them. */
+func rlockAll[K comparable, S any](sets []*SortedSet[K, S]) func() {
+	unique := make([]*SortedSet[K, S], 0, len(sets))
+	seen := make(map[uint64]bool, len(sets))
+	for _, z := range sets {
+		if !seen[z.id] {
+			seen[z.id] = true
+			unique = append(unique, z)
+		}
+	}
+	sort.Slice(unique, func(i, j int) bool {
+		return unique[i].id < unique[j].id
+	})
+	for _, z := range unique {
+		z.lock.RLock()
+	}
+	return func() {
+		for i := len(unique) - 1; i >= 0; i-- {
+			unique[i].lock.RUnlock()
+		}
+	}
+}
+
+// bySize returns the indexes of sets, from the smallest set to the largest.
+// The sets must be locked.
+func bySize[K comparable, S any](sets []*SortedSet[K, S]) []int {
+	order := make([]int, len(sets))
+	sizes := make([]int64, len(sets))
+	for i, z := range sets {
+		order[i] = i
+		sizes[i] = z.zsl.length
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return sizes[order[a]] < sizes[order[b]]
+	})
+	return order
+}
@@ -0,0 +1,136 @@
+package zset
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func dump(z *SortedSet[string, float64]) map[string]float64 {
+	m := make(map[string]float64)
+	z.Range(0, -1, func(score float64, k string) {
+		m[k] = score
+	})
+	return m
+}
+
+func sameMembers(a, b map[string]float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || w != v {
+			return false
+		}
+	}
+	return true
+}
+
+func TestAlgebra(t *testing.T) {
+	z1 := New[string]()
+	z1.Set(1, "a")
+	z1.Set(2, "b")
+	z1.Set(3, "c")
+	z2 := New[string]()
+	z2.Set(10, "b")
+	z2.Set(20, "c")
+	z2.Set(30, "d")
+
+	if got := dump(Union(nil, AggregateSum, z1, z2)); !sameMembers(got, map[string]float64{
+		"a": 1, "b": 12, "c": 23, "d": 30,
+	}) {
+		t.Error(got)
+	}
+	if got := dump(Union([]float64{2, 0.5}, AggregateMax, z1, z2)); !sameMembers(got, map[string]float64{
+		"a": 2, "b": 5, "c": 10, "d": 15,
+	}) {
+		t.Error(got)
+	}
+	if got := dump(Inter(nil, AggregateMin, z1, z2)); !sameMembers(got, map[string]float64{
+		"b": 2, "c": 3,
+	}) {
+		t.Error(got)
+	}
+	if got := dump(Inter([]float64{1, -1}, AggregateSum, z2, z1)); !sameMembers(got, map[string]float64{
+		"b": 8, "c": 17,
+	}) {
+		t.Error(got)
+	}
+	if got := dump(Diff(z1, z2)); !sameMembers(got, map[string]float64{"a": 1}) {
+		t.Error(got)
+	}
+	if got := dump(Diff(z2)); !sameMembers(got, dump(z2)) {
+		t.Error(got)
+	}
+	if n := InterCard(0, z1, z2); n != 2 {
+		t.Error(n)
+	}
+	if n := InterCard(1, z1, z2); n != 1 {
+		t.Error(n)
+	}
+	if n := InterCard(5, z1, z2, z1); n != 2 {
+		t.Error(n)
+	}
+	if n := InterCard(0, z1, New[string]()); n != 0 {
+		t.Error(n)
+	}
+
+	inf := New[string]()
+	inf.Set(math.Inf(1), "a")
+	neg := New[string]()
+	neg.Set(math.Inf(-1), "a")
+	if got := dump(Union(nil, AggregateSum, inf, neg)); got["a"] != 0 {
+		t.Error(got)
+	}
+	if got := dump(Union([]float64{0}, AggregateSum, inf)); got["a"] != 0 {
+		t.Error(got)
+	}
+
+	if n := UnionStore(z1, nil, AggregateSum, z1, z2); n != 4 || z1.Length() != 4 {
+		t.Error(n, z1.Length())
+	}
+	if rank, score := z1.GetRank("d", true); rank != 0 || score != 30 {
+		t.Error(rank, score)
+	}
+	if n := InterStore(z1, nil, AggregateSum, z1, New[string]()); n != 0 || z1.Length() != 0 {
+		t.Error(n, z1.Length())
+	}
+	if n := DiffStore(z1, z2, New[string]()); n != 3 || !sameMembers(dump(z1), dump(z2)) {
+		t.Error(n, dump(z1))
+	}
+}
+
+func TestStoreOrder(t *testing.T) {
+	src := New[string]()
+	src.Set(1, "a")
+	src.Set(1, "b")
+	src.Set(1, "c")
+	reversed := func(a, b string) int { return strings.Compare(b, a) }
+	keys := func(z *SortedSet[string, float64]) string {
+		all := ""
+		z.Range(0, -1, func(score float64, k string) {
+			all += k
+		})
+		return all
+	}
+
+	for i, store := range []func(dst *SortedSet[string, float64]) int64{
+		func(dst *SortedSet[string, float64]) int64 { return UnionStore(dst, nil, AggregateSum, src) },
+		func(dst *SortedSet[string, float64]) int64 { return InterStore(dst, nil, AggregateMax, src, src) },
+		func(dst *SortedSet[string, float64]) int64 { return DiffStore(dst, src) },
+	} {
+		dst := NewFunc(reversed)
+		if n := store(dst); n != 3 || keys(dst) != "cba" {
+			t.Error(i, n, keys(dst))
+		}
+		/* Inserts keep using the order of dst. */
+		dst.Set(1, "bb")
+		dst.Set(1, "d")
+		if got := keys(dst); got != "dcbbba" {
+			t.Error(i, got)
+		}
+		if rank, _ := dst.GetRank("a", false); rank != 4 {
+			t.Error(i, rank)
+		}
+	}
+}
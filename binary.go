@@ -0,0 +1,476 @@
+package zset
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"math"
+	"reflect"
+	"strconv"
+)
+
+/*-----------------------------------------------------------------------------
+ * Binary snapshots, encoding.BinaryMarshaler and encoding.BinaryUnmarshaler
+ *
+ * The format is:
+ *
+ *   "ZSET" version:byte keyKind:byte scoreKind:byte count:uvarint
+ *   count * (key score), in ascending order
+ *   crc32:4 bytes, little endian IEEE CRC-32 of everything before
+ *
+ * The kinds are the reflect.Kind of the key and score types, and values
+ * are encoded by kind: signed integers as zig-zag varints, unsigned ones
+ * as uvarints, floats as their IEEE 754 bits in little endian order,
+ * strings as their uvarint length followed by their bytes and booleans as
+ * a byte, 0 or 1.
+ *----------------------------------------------------------------------------*/
+
+const (
+	binaryMagic   = "ZSET"
+	binaryVersion = 1
+)
+
+// ErrUnsupportedType is returned when encoding or decoding a SortedSet whose
+// key or score type is not an integer, float, string or bool kind.
+var ErrUnsupportedType = errors.New("key or score type is not supported by the encoding")
+
+// ErrBadEncoding is returned when decoding data which is truncated, was not
+// produced by MarshalBinary, or was produced for other key or score types.
+var ErrBadEncoding = errors.New("invalid or truncated encoding")
+
+// ErrChecksum is returned when the checksum of the data doesn't match.
+var ErrChecksum = errors.New("checksum mismatch")
+
+// ErrDuplicateKey is returned when decoding several elements with the
+// same key.
+var ErrDuplicateKey = errors.New("duplicate key")
+
+var (
+	errOutOfOrder = errors.New("elements are not in ascending order")
+	errNotCreated = errors.New("zset: decoding needs a SortedSet made by a constructor")
+)
+
+type codec[T any] struct {
+	kind reflect.Kind
+	put  func(b []byte, v T) []byte
+	get  func(b []byte) (T, []byte, error)
+}
+
+/* The codecs of the built-in types, which don't go through reflect as it
+ * allocates for every value. */
+var (
+	stringCodec = codec[string]{
+		kind: reflect.String,
+		put: func(b []byte, v string) []byte {
+			b = appendUvarint(b, uint64(len(v)))
+			return append(b, v...)
+		},
+		get: func(b []byte) (string, []byte, error) {
+			l, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < l {
+				return "", nil, ErrBadEncoding
+			}
+			return string(b[n : n+int(l)]), b[n+int(l):], nil
+		},
+	}
+	float32Codec = codec[float32]{
+		kind: reflect.Float32,
+		put: func(b []byte, v float32) []byte {
+			return appendUint32(b, math.Float32bits(v))
+		},
+		get: func(b []byte) (float32, []byte, error) {
+			if len(b) < 4 {
+				return 0, nil, ErrBadEncoding
+			}
+			return math.Float32frombits(binary.LittleEndian.Uint32(b)), b[4:], nil
+		},
+	}
+	float64Codec = codec[float64]{
+		kind: reflect.Float64,
+		put: func(b []byte, v float64) []byte {
+			return appendUint64(b, math.Float64bits(v))
+		},
+		get: func(b []byte) (float64, []byte, error) {
+			if len(b) < 8 {
+				return 0, nil, ErrBadEncoding
+			}
+			return math.Float64frombits(binary.LittleEndian.Uint64(b)), b[8:], nil
+		},
+	}
+	boolCodec = codec[bool]{
+		kind: reflect.Bool,
+		put: func(b []byte, v bool) []byte {
+			if v {
+				return append(b, 1)
+			}
+			return append(b, 0)
+		},
+		get: func(b []byte) (bool, []byte, error) {
+			if len(b) < 1 || b[0] > 1 {
+				return false, nil, ErrBadEncoding
+			}
+			return b[0] == 1, b[1:], nil
+		},
+	}
+)
+
+/* Integers of every size share one encoding, decoding rejects the values
+ * which don't fit in T. */
+func intCodec[T int | int8 | int16 | int32 | int64](kind reflect.Kind) codec[T] {
+	return codec[T]{
+		kind: kind,
+		put: func(b []byte, v T) []byte {
+			return appendVarint(b, int64(v))
+		},
+		get: func(b []byte) (T, []byte, error) {
+			x, n := binary.Varint(b)
+			if n <= 0 || int64(T(x)) != x {
+				return 0, nil, ErrBadEncoding
+			}
+			return T(x), b[n:], nil
+		},
+	}
+}
+
+func uintCodec[T uint | uint8 | uint16 | uint32 | uint64 | uintptr](kind reflect.Kind) codec[T] {
+	return codec[T]{
+		kind: kind,
+		put: func(b []byte, v T) []byte {
+			return appendUvarint(b, uint64(v))
+		},
+		get: func(b []byte) (T, []byte, error) {
+			x, n := binary.Uvarint(b)
+			if n <= 0 || uint64(T(x)) != x {
+				return 0, nil, ErrBadEncoding
+			}
+			return T(x), b[n:], nil
+		},
+	}
+}
+
+func codecOf[T any]() (codec[T], error) {
+	var c any
+	var zero T
+	switch any(zero).(type) {
+	case string:
+		c = stringCodec
+	case int:
+		c = intCodec[int](reflect.Int)
+	case int8:
+		c = intCodec[int8](reflect.Int8)
+	case int16:
+		c = intCodec[int16](reflect.Int16)
+	case int32:
+		c = intCodec[int32](reflect.Int32)
+	case int64:
+		c = intCodec[int64](reflect.Int64)
+	case uint:
+		c = uintCodec[uint](reflect.Uint)
+	case uint8:
+		c = uintCodec[uint8](reflect.Uint8)
+	case uint16:
+		c = uintCodec[uint16](reflect.Uint16)
+	case uint32:
+		c = uintCodec[uint32](reflect.Uint32)
+	case uint64:
+		c = uintCodec[uint64](reflect.Uint64)
+	case uintptr:
+		c = uintCodec[uintptr](reflect.Uintptr)
+	case float32:
+		c = float32Codec
+	case float64:
+		c = float64Codec
+	case bool:
+		c = boolCodec
+	default:
+		return reflectCodecOf[T]()
+	}
+	return c.(codec[T]), nil
+}
+
+/* The codecs of named types, by kind. They encode values the same way as
+ * the ones of the built-in types. */
+func reflectCodecOf[T any]() (codec[T], error) {
+	c := codec[T]{kind: reflect.TypeOf((*T)(nil)).Elem().Kind()}
+	switch c.kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		c.put = func(b []byte, v T) []byte {
+			return appendVarint(b, reflect.ValueOf(v).Int())
+		}
+		c.get = func(b []byte) (v T, rest []byte, err error) {
+			x, n := binary.Varint(b)
+			rv := reflect.ValueOf(&v).Elem()
+			if n <= 0 || rv.OverflowInt(x) {
+				return v, nil, ErrBadEncoding
+			}
+			rv.SetInt(x)
+			return v, b[n:], nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		c.put = func(b []byte, v T) []byte {
+			return appendUvarint(b, reflect.ValueOf(v).Uint())
+		}
+		c.get = func(b []byte) (v T, rest []byte, err error) {
+			x, n := binary.Uvarint(b)
+			rv := reflect.ValueOf(&v).Elem()
+			if n <= 0 || rv.OverflowUint(x) {
+				return v, nil, ErrBadEncoding
+			}
+			rv.SetUint(x)
+			return v, b[n:], nil
+		}
+	case reflect.Float32:
+		c.put = func(b []byte, v T) []byte {
+			return appendUint32(b, math.Float32bits(float32(reflect.ValueOf(v).Float())))
+		}
+		c.get = func(b []byte) (v T, rest []byte, err error) {
+			if len(b) < 4 {
+				return v, nil, ErrBadEncoding
+			}
+			x := math.Float32frombits(binary.LittleEndian.Uint32(b))
+			reflect.ValueOf(&v).Elem().SetFloat(float64(x))
+			return v, b[4:], nil
+		}
+	case reflect.Float64:
+		c.put = func(b []byte, v T) []byte {
+			return appendUint64(b, math.Float64bits(reflect.ValueOf(v).Float()))
+		}
+		c.get = func(b []byte) (v T, rest []byte, err error) {
+			if len(b) < 8 {
+				return v, nil, ErrBadEncoding
+			}
+			x := math.Float64frombits(binary.LittleEndian.Uint64(b))
+			reflect.ValueOf(&v).Elem().SetFloat(x)
+			return v, b[8:], nil
+		}
+	case reflect.String:
+		c.put = func(b []byte, v T) []byte {
+			s := reflect.ValueOf(v).String()
+			b = appendUvarint(b, uint64(len(s)))
+			return append(b, s...)
+		}
+		c.get = func(b []byte) (v T, rest []byte, err error) {
+			l, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < l {
+				return v, nil, ErrBadEncoding
+			}
+			reflect.ValueOf(&v).Elem().SetString(string(b[n : n+int(l)]))
+			return v, b[n+int(l):], nil
+		}
+	case reflect.Bool:
+		c.put = func(b []byte, v T) []byte {
+			if reflect.ValueOf(v).Bool() {
+				return append(b, 1)
+			}
+			return append(b, 0)
+		}
+		c.get = func(b []byte) (v T, rest []byte, err error) {
+			if len(b) < 1 || b[0] > 1 {
+				return v, nil, ErrBadEncoding
+			}
+			reflect.ValueOf(&v).Elem().SetBool(b[0] == 1)
+			return v, b[1:], nil
+		}
+	default:
+		return c, ErrUnsupportedType
+	}
+	return c, nil
+}
+
+/* Keys and scores as text, for JSON objects and the journal. Infinite
+ * floats are written "+inf" and "-inf", like Redis does. */
+type textCodec[T any] struct {
+	format func(v T) string
+	parse  func(s string) (T, error)
+}
+
+func textCodecOf[T any]() (textCodec[T], error) {
+	var c textCodec[T]
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		c.format = func(v T) string {
+			return strconv.FormatInt(reflect.ValueOf(v).Int(), 10)
+		}
+		c.parse = func(s string) (v T, err error) {
+			x, err := strconv.ParseInt(s, 10, t.Bits())
+			reflect.ValueOf(&v).Elem().SetInt(x)
+			return v, err
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		c.format = func(v T) string {
+			return strconv.FormatUint(reflect.ValueOf(v).Uint(), 10)
+		}
+		c.parse = func(s string) (v T, err error) {
+			x, err := strconv.ParseUint(s, 10, t.Bits())
+			reflect.ValueOf(&v).Elem().SetUint(x)
+			return v, err
+		}
+	case reflect.Float32, reflect.Float64:
+		c.format = func(v T) string {
+			f := reflect.ValueOf(v).Float()
+			if math.IsInf(f, 1) {
+				return "+inf"
+			} else if math.IsInf(f, -1) {
+				return "-inf"
+			}
+			return strconv.FormatFloat(f, 'g', -1, t.Bits())
+		}
+		c.parse = func(s string) (v T, err error) {
+			x, err := strconv.ParseFloat(s, t.Bits())
+			reflect.ValueOf(&v).Elem().SetFloat(x)
+			return v, err
+		}
+	case reflect.String:
+		c.format = func(v T) string {
+			return reflect.ValueOf(v).String()
+		}
+		c.parse = func(s string) (v T, err error) {
+			reflect.ValueOf(&v).Elem().SetString(s)
+			return v, nil
+		}
+	case reflect.Bool:
+		c.format = func(v T) string {
+			return strconv.FormatBool(reflect.ValueOf(v).Bool())
+		}
+		c.parse = func(s string) (v T, err error) {
+			x, err := strconv.ParseBool(s)
+			reflect.ValueOf(&v).Elem().SetBool(x)
+			return v, err
+		}
+	default:
+		return c, ErrUnsupportedType
+	}
+	return c, nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler. Only the elements are
+// encoded, not how the set orders them, nor the values of a SortedMap.
+func (z *SortedSet[K, S]) MarshalBinary() ([]byte, error) {
+	keys, err := codecOf[K]()
+	if err != nil {
+		return nil, err
+	}
+	scores, err := codecOf[S]()
+	if err != nil {
+		return nil, err
+	}
+
+	z.lock.RLock()
+	b := make([]byte, 0, 16+z.zsl.length*8)
+	b = append(b, binaryMagic...)
+	b = append(b, binaryVersion, byte(keys.kind), byte(scores.kind))
+	b = appendUvarint(b, uint64(z.zsl.length))
+	for x := z.zsl.header.level[0].forward; x != nil; x = x.level[0].forward {
+		b = keys.put(b, x.objID)
+		b = scores.put(b, x.score)
+	}
+	z.lock.RUnlock()
+
+	return appendUint32(b, crc32.ChecksumIEEE(b)), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler, it replaces the
+// elements of z with the ones encoded in data. z must have been created by
+// one of the constructors, which tell how to order the elements. As they
+// are encoded in order, the skiplist is built in O(N) instead of inserting
+// them one by one, and data which is not in the order of z is rejected
+// with ErrBadEncoding, as well as duplicate keys.
+func (z *SortedSet[K, S]) UnmarshalBinary(data []byte) error {
+	if z.compareScore == nil {
+		return errNotCreated
+	}
+	keys, err := codecOf[K]()
+	if err != nil {
+		return err
+	}
+	scores, err := codecOf[S]()
+	if err != nil {
+		return err
+	}
+
+	header := len(binaryMagic) + 3
+	if len(data) < header+4 || string(data[:len(binaryMagic)]) != binaryMagic {
+		return ErrBadEncoding
+	}
+	body, sum := data[:len(data)-4], data[len(data)-4:]
+	if crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(sum) {
+		return ErrChecksum
+	}
+	if body[4] != binaryVersion || body[5] != byte(keys.kind) || body[6] != byte(scores.kind) {
+		return ErrBadEncoding
+	}
+	b := body[header:]
+	count, n := binary.Uvarint(b)
+	/* Every element takes at least 2 bytes. */
+	if n <= 0 || count > uint64(len(b)-n)/2 {
+		return ErrBadEncoding
+	}
+	b = b[n:]
+
+	members := make([]Member[K, S], count)
+	for i := range members {
+		m := &members[i]
+		if m.Key, b, err = keys.get(b); err != nil {
+			return err
+		}
+		if m.Score, b, err = scores.get(b); err != nil {
+			return err
+		}
+	}
+	if len(b) != 0 {
+		return ErrBadEncoding
+	}
+
+	src := z.empty()
+	if err := src.build(members); err != nil {
+		return ErrBadEncoding
+	}
+	z.store(src)
+	return nil
+}
+
+/* Fills an empty set with members, which must be in strictly ascending
+ * order, in O(N). */
+func (z *SortedSet[K, S]) build(members []Member[K, S]) error {
+	for i, m := range members {
+		if z.nan != nil && z.nan(m.Score) {
+			return ErrNaN
+		}
+		if _, ok := z.dict[m.Key]; ok {
+			return ErrDuplicateKey
+		}
+		if i > 0 {
+			prev := members[i-1]
+			c := z.compareScore(prev.Score, m.Score)
+			if c > 0 || (c == 0 && z.compare(prev.Key, m.Key) >= 0) {
+				return errOutOfOrder
+			}
+		}
+		z.dict[m.Key] = m.Score
+	}
+	z.zsl.zslBuild(members)
+	return nil
+}
+
+func appendUvarint(b []byte, x uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], x)]...)
+}
+
+func appendVarint(b []byte, x int64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutVarint(buf[:], x)]...)
+}
+
+func appendUint32(b []byte, x uint32) []byte {
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], x)
+	return append(b, buf[:]...)
+}
+
+func appendUint64(b []byte, x uint64) []byte {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], x)
+	return append(b, buf[:]...)
+}
@@ -0,0 +1,258 @@
+package zset
+
+import (
+	"encoding"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var (
+	_ encoding.BinaryMarshaler   = New[string]()
+	_ encoding.BinaryUnmarshaler = New[string]()
+)
+
+func TestMarshalBinary(t *testing.T) {
+	z := New[string]()
+	for i := 0; i < 1000; i++ {
+		z.Set(float64(i%37), "k"+strconv.Itoa(i))
+	}
+	z.Set(math.Inf(-1), "-inf")
+	z.Set(math.Inf(1), "+inf")
+	data, err := z.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	y := New[string]()
+	y.Set(1, "old")
+	if err := y.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if y.Length() != z.Length() {
+		t.Fatal(y.Length())
+	}
+	if _, ok := y.GetScore("old"); ok {
+		t.Error("old element kept")
+	}
+	/* Ranks only work if the spans were built right. */
+	z.Range(0, -1, func(score float64, k string) {
+		zr, _ := z.GetRank(k, false)
+		yr, ys := y.GetRank(k, false)
+		if zr != yr || ys != score {
+			t.Fatal(k, zr, yr, ys)
+		}
+		if key, _ := y.GetDataByRank(y.Length()-1-yr, true); key != k {
+			t.Fatal(k, key)
+		}
+	})
+	got := ""
+	y.RevRange(0, 1, func(score float64, k string) {
+		got += k + " "
+	})
+	if got != "+inf k998 " {
+		t.Error(got)
+	}
+
+	/* The set keeps working after a bulk build. */
+	y.Set(0.5, "new")
+	y.Delete("k0")
+	if rank, _ := y.GetRank("new", false); rank != 28 {
+		t.Error(rank)
+	}
+	if n := y.RemoveRangeByRank(0, 500, nil); n != 501 || y.Length() != 501 {
+		t.Error(n, y.Length())
+	}
+}
+
+func TestMarshalBinaryKinds(t *testing.T) {
+	ints := NewOf[uint64, int8]()
+	ints.Set(-128, math.MaxUint64)
+	ints.Set(127, 0)
+	data, err := ints.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	back := NewOf[uint64, int8]()
+	if err := back.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if score, _ := back.GetScore(math.MaxUint64); score != -128 || back.Length() != 2 {
+		t.Error(score, back.Length())
+	}
+	if err := NewOf[uint64, int16]().UnmarshalBinary(data); err != ErrBadEncoding {
+		t.Error(err)
+	}
+
+	/* The fast int64 codec and reflect agree on the format. */
+	type id int64
+	int64s := NewOf[int64, int64]()
+	int64s.Set(math.MinInt64, math.MaxInt64)
+	int64s.Set(math.MaxInt64, -1)
+	data, _ = int64s.MarshalBinary()
+	ids := NewOf[id, int64]()
+	if err := ids.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if score, _ := ids.GetScore(math.MaxInt64); score != math.MinInt64 || ids.Length() != 2 {
+		t.Error(score, ids.Length())
+	}
+
+	type name string
+	floats := NewOf[name, float32]()
+	floats.Set(1.5, "a")
+	data, _ = floats.MarshalBinary()
+	if err := NewOf[name, float32]().UnmarshalBinary(data); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := NewComposite[string, result]().MarshalBinary(); err != ErrUnsupportedType {
+		t.Error(err)
+	}
+}
+
+func TestBinaryCodecs(t *testing.T) {
+	testCodec(t, "", "a", strings.Repeat("z", 300))
+	testCodec(t, math.MinInt, -1, 0, math.MaxInt)
+	testCodec[int8](t, math.MinInt8, 0, math.MaxInt8)
+	testCodec[int16](t, math.MinInt16, 0, math.MaxInt16)
+	testCodec[int32](t, math.MinInt32, 0, math.MaxInt32)
+	testCodec[int64](t, math.MinInt64, 0, math.MaxInt64)
+	testCodec[uint](t, 0, 1, math.MaxUint)
+	testCodec[uint8](t, 0, 1, math.MaxUint8)
+	testCodec[uint16](t, 0, 1, math.MaxUint16)
+	testCodec[uint32](t, 0, 1, math.MaxUint32)
+	testCodec[uint64](t, 0, 1, math.MaxUint64)
+	testCodec[uintptr](t, 0, 1, ^uintptr(0))
+	testCodec[float32](t, float32(math.Inf(-1)), -1.5, 0, math.MaxFloat32)
+	testCodec(t, math.Inf(-1), -1.5, 0, math.MaxFloat64)
+	testCodec(t, false, true)
+
+	/* Values which don't fit are rejected. */
+	if _, _, err := intCodec[int8](reflect.Int8).get(appendVarint(nil, math.MaxInt8+1)); err != ErrBadEncoding {
+		t.Error(err)
+	}
+	if _, _, err := uintCodec[uint16](reflect.Uint16).get(appendUvarint(nil, math.MaxUint16+1)); err != ErrBadEncoding {
+		t.Error(err)
+	}
+	if _, _, err := boolCodec.get([]byte{2}); err != ErrBadEncoding {
+		t.Error(err)
+	}
+
+	flags := NewFuncOf[bool, uint8](func(a, b bool) int {
+		if a == b {
+			return 0
+		} else if b {
+			return -1
+		}
+		return 1
+	})
+	flags.Set(2, false)
+	flags.Set(1, true)
+	data, err := flags.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	type flag bool
+	back := NewFuncOf[flag, uint8](func(a, b flag) int {
+		return flags.compare(bool(a), bool(b))
+	})
+	if err := back.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if score, _ := back.GetScore(true); score != 1 || back.Length() != 2 {
+		t.Error(score, back.Length())
+	}
+}
+
+/* Round-trips values through the codec of T, which must encode them like
+ * the reflect one of named types does. */
+func testCodec[T comparable](t *testing.T, values ...T) {
+	t.Helper()
+	c, err := codecOf[T]()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := reflectCodecOf[T]()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.kind != r.kind {
+		t.Errorf("%T: kind %v, want %v", values[0], c.kind, r.kind)
+	}
+	for _, v := range values {
+		b := c.put(nil, v)
+		if want := r.put(nil, v); string(b) != string(want) {
+			t.Errorf("%T %v: encoded %x, want %x", v, v, b, want)
+		}
+		got, rest, err := c.get(append(b, 0xFF))
+		if err != nil || got != v || len(rest) != 1 {
+			t.Errorf("%T %v: decoded %v %x %v", v, v, got, rest, err)
+		}
+		if _, _, err := c.get(b[:len(b)-1]); err != ErrBadEncoding {
+			t.Errorf("%T %v: truncated: %v", v, v, err)
+		}
+	}
+}
+
+func TestUnmarshalBinaryErrors(t *testing.T) {
+	z := New[string]()
+	z.Set(1, "a")
+	z.Set(2, "b")
+	data, _ := z.MarshalBinary()
+
+	y := New[string]()
+	bad := append([]byte(nil), data...)
+	bad[len(bad)-6] ^= 1
+	if err := y.UnmarshalBinary(bad); err != ErrChecksum {
+		t.Error(err)
+	}
+	if err := y.UnmarshalBinary(data[:5]); err != ErrBadEncoding {
+		t.Error(err)
+	}
+	if err := y.UnmarshalBinary(nil); err != ErrBadEncoding {
+		t.Error(err)
+	}
+	if err := NewOf[string, int64]().UnmarshalBinary(data); err != ErrBadEncoding {
+		t.Error(err)
+	}
+	/* Same scores, but keys in reverse order. */
+	z = New[string]()
+	z.Set(1, "a")
+	z.Set(1, "b")
+	data, _ = z.MarshalBinary()
+	desc := NewFunc(func(a, b string) int { return strings.Compare(b, a) })
+	if err := desc.UnmarshalBinary(data); err != ErrBadEncoding || desc.Length() != 0 {
+		t.Error(err, desc.Length())
+	}
+	if err := new(SortedSet[string, float64]).UnmarshalBinary(data); err == nil {
+		t.Error("zero SortedSet decoded")
+	}
+}
+
+func BenchmarkMarshalBinary(b *testing.B) {
+	z := New[string]()
+	for i := 0; i < 10000; i++ {
+		z.Set(float64(i), "k"+strconv.Itoa(i))
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		z.MarshalBinary()
+	}
+}
+
+func BenchmarkUnmarshalBinary(b *testing.B) {
+	z := New[string]()
+	for i := 0; i < 10000; i++ {
+		z.Set(float64(i), "k"+strconv.Itoa(i))
+	}
+	data, _ := z.MarshalBinary()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		New[string]().UnmarshalBinary(data)
+	}
+}
@@ -0,0 +1,137 @@
+package zset
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+/*-----------------------------------------------------------------------------
+ * Blocking pops, BZPOPMIN / BZPOPMAX / BZMPOP
+ *----------------------------------------------------------------------------*/
+
+type (
+	// waiter is a goroutine blocked on one or more sets.
+	// Whoever flips claimed from 0 to 1 owns it: either a set serving it,
+	// or the waiter itself giving up because its context is done.
+	waiter[K comparable, S any] struct {
+		claimed int32
+		done    chan struct{}
+		index   int
+		// pop is called with the write lock of the set serving
+		// the waiter held, and keeps what it pops.
+		pop func(z *SortedSet[K, S])
+	}
+	blockedEntry[K comparable, S any] struct {
+		w     *waiter[K, S]
+		index int
+	}
+)
+
+// serveBlocked hands elements to the waiters of z in FIFO order, for as long
+// as there are any. It must be called with the write lock held, after
+// every operation that may add elements.
+func (z *SortedSet[K, S]) serveBlocked() {
+	for len(z.blocked) > 0 && z.zsl.length > 0 {
+		b := z.blocked[0]
+		z.blocked[0] = blockedEntry[K, S]{}
+		z.blocked = z.blocked[1:]
+		if !atomic.CompareAndSwapInt32(&b.w.claimed, 0, 1) {
+			/* Cancelled, or already served by another set. */
+			continue
+		}
+		b.w.index = b.index
+		b.w.pop(z)
+		close(b.w.done)
+	}
+	if len(z.blocked) == 0 {
+		z.blocked = nil
+	}
+}
+
+func (z *SortedSet[K, S]) unblock(w *waiter[K, S]) {
+	z.lock.Lock()
+	defer z.lock.Unlock()
+	n := 0
+	for _, b := range z.blocked {
+		if b.w != w {
+			z.blocked[n] = b
+			n++
+		}
+	}
+	for i := n; i < len(z.blocked); i++ {
+		z.blocked[i] = blockedEntry[K, S]{}
+	}
+	z.blocked = z.blocked[:n]
+}
+
+// BlockingPopMin implements BZPOPMIN with a count. It waits until the set is
+// not empty or ctx is done, then pops like PopMin. Waiters are served in
+// the order they started waiting.
+func (z *SortedSet[K, S]) BlockingPopMin(ctx context.Context, count int64) ([]Member[K, S], error) {
+	_, members, err := BlockingMPop(ctx, count, false, z)
+	return members, err
+}
+
+// BlockingPopMax implements BZPOPMAX with a count. It waits until the set is
+// not empty or ctx is done, then pops like PopMax. Waiters are served in
+// the order they started waiting.
+func (z *SortedSet[K, S]) BlockingPopMax(ctx context.Context, count int64) ([]Member[K, S], error) {
+	_, members, err := BlockingMPop(ctx, count, true, z)
+	return members, err
+}
+
+// BlockingMPop implements BZMPOP, the blocking variant of MPop. If all the sets
+// are empty it waits until one of them gets an element, or until ctx is done,
+// in which case it returns ctx.Err().
+func BlockingMPop[K comparable, S any](ctx context.Context, count int64, reverse bool, sets ...*SortedSet[K, S]) (int, []Member[K, S], error) {
+	if count <= 0 || len(sets) == 0 {
+		return -1, nil, nil
+	}
+	var members []Member[K, S]
+	i, err := blockingPop(ctx, sets, func(z *SortedSet[K, S]) {
+		members = z.pop(count, reverse)
+	})
+	return i, members, err
+}
+
+// blockingPop waits until one of sets is not empty, or until ctx is done,
+// and calls pop with the first non-empty set while holding its write lock.
+// It returns the index of that set in sets, or -1 and ctx.Err().
+func blockingPop[K comparable, S any](ctx context.Context, sets []*SortedSet[K, S], pop func(z *SortedSet[K, S])) (int, error) {
+	w := &waiter[K, S]{
+		done:  make(chan struct{}),
+		index: -1,
+		pop:   pop,
+	}
+	for i, z := range sets {
+		z.lock.Lock()
+		if z.zsl.length > 0 {
+			/* Unless a set we already registered with got there first. */
+			if atomic.CompareAndSwapInt32(&w.claimed, 0, 1) {
+				w.index = i
+				w.pop(z)
+				close(w.done)
+			}
+			z.lock.Unlock()
+			break
+		}
+		z.blocked = append(z.blocked, blockedEntry[K, S]{w: w, index: i})
+		z.lock.Unlock()
+	}
+
+	var err error
+	select {
+	case <-w.done:
+	case <-ctx.Done():
+		if atomic.CompareAndSwapInt32(&w.claimed, 0, 1) {
+			err = ctx.Err()
+		} else {
+			/* Served in the meantime, don't lose the elements. */
+			<-w.done
+		}
+	}
+	for _, z := range sets {
+		z.unblock(w)
+	}
+	return w.index, err
+}
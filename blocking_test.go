@@ -0,0 +1,106 @@
+package zset
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func waitBlocked[K comparable, S any](t *testing.T, z *SortedSet[K, S], n int) {
+	for i := 0; i < 1000; i++ {
+		z.lock.RLock()
+		l := len(z.blocked)
+		z.lock.RUnlock()
+		if l == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("waiters did not block")
+}
+
+func TestBlockingPop(t *testing.T) {
+	z := New[string]()
+	z.Set(1, "a")
+	z.Set(2, "b")
+	m, err := z.BlockingPopMax(context.Background(), 1)
+	if err != nil || len(m) != 1 || m[0].Key != "b" {
+		t.Error(m, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	m, err = z.BlockingPopMin(ctx, 5)
+	if err != nil || len(m) != 1 || m[0].Key != "a" {
+		t.Error(m, err)
+	}
+	m, err = z.BlockingPopMin(ctx, 1)
+	if err != context.DeadlineExceeded || m != nil {
+		t.Error(m, err)
+	}
+	if len(z.blocked) != 0 {
+		t.Error("cancelled waiter is still registered")
+	}
+}
+
+func TestBlockingPopFIFO(t *testing.T) {
+	z := New[string]()
+	results := make([]chan string, 3)
+	for i := range results {
+		ch := make(chan string, 1)
+		results[i] = ch
+		go func() {
+			m, err := z.BlockingPopMin(context.Background(), 1)
+			if err != nil || len(m) != 1 {
+				t.Error(m, err)
+				ch <- ""
+				return
+			}
+			ch <- m[0].Key
+		}()
+		waitBlocked(t, z, i+1)
+	}
+	z.Set(1, "a")
+	z.IncrBy(2, "b")
+	z.Add(3, "c", NX)
+	for i, want := range []string{"a", "b", "c"} {
+		if got := <-results[i]; got != want {
+			t.Error(i, got)
+		}
+	}
+	if z.Length() != 0 {
+		t.Error(z.Length())
+	}
+}
+
+func TestBlockingMPop(t *testing.T) {
+	z1 := New[int64]()
+	z2 := New[int64]()
+	type result struct {
+		i       int
+		members []Member[int64, float64]
+		err     error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		i, m, err := BlockingMPop(context.Background(), 2, true, z1, z2)
+		ch <- result{i, m, err}
+	}()
+	waitBlocked(t, z1, 1)
+	waitBlocked(t, z2, 1)
+	z2.Set(1, 100)
+	r := <-ch
+	if r.err != nil || r.i != 1 || len(r.members) != 1 || r.members[0].Key != 100 {
+		t.Error(r)
+	}
+	waitBlocked(t, z1, 0)
+	z1.Set(1, 100)
+	if z1.Length() != 1 {
+		t.Error("z1 should not serve a waiter which is gone")
+	}
+
+	i, m, err := BlockingMPop(context.Background(), 1, false, z2, z1)
+	if err != nil || i != 1 || len(m) != 1 {
+		t.Error(i, m, err)
+	}
+}
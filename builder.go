@@ -0,0 +1,100 @@
+package zset
+
+import "sort"
+
+/*-----------------------------------------------------------------------------
+ * Bulk construction
+ *----------------------------------------------------------------------------*/
+
+// Builder replaces the elements of a SortedSet with many elements at once,
+// like when restoring a set. Elements added in ascending order, by score
+// and then by key, are linked into the skiplist level by level in a single
+// O(N) pass instead of being inserted one by one; any other order falls
+// back to sorting them first. The set is not locked while building.
+type Builder[K comparable, S any] struct {
+	z       *SortedSet[K, S]
+	members []Member[K, S]
+	sorted  bool
+}
+
+// NewBuilder returns a Builder of the elements of z, which must have been
+// made by one of the constructors, as it tells how to order them.
+func NewBuilder[K comparable, S any](z *SortedSet[K, S]) *Builder[K, S] {
+	if z.compareScore == nil {
+		panic("zset: NewBuilder needs a SortedSet made by a constructor")
+	}
+	return &Builder[K, S]{z: z, sorted: true}
+}
+
+// Grow makes room for n more elements, to add them without reallocating.
+func (b *Builder[K, S]) Grow(n int) {
+	if n > cap(b.members)-len(b.members) {
+		members := make([]Member[K, S], len(b.members), len(b.members)+n)
+		copy(members, b.members)
+		b.members = members
+	}
+}
+
+// Add appends an element.
+func (b *Builder[K, S]) Add(score S, key K) {
+	m := Member[K, S]{Key: key, Score: score}
+	if n := len(b.members); b.sorted && n > 0 && b.z.compareMembers(b.members[n-1], m) >= 0 {
+		b.sorted = false
+	}
+	b.members = append(b.members, m)
+}
+
+// Len returns the number of elements added.
+func (b *Builder[K, S]) Len() int {
+	return len(b.members)
+}
+
+// Sorted reports whether the elements were added in ascending order so
+// far, in which case Build doesn't need to sort them.
+func (b *Builder[K, S]) Sorted() bool {
+	return b.sorted
+}
+
+// Build replaces the elements of the set with the ones added and resets
+// the Builder. When a score is NaN or a key was added twice, it returns
+// ErrNaN or ErrDuplicateKey and the set is left unchanged.
+func (b *Builder[K, S]) Build() error {
+	members, sorted := b.members, b.sorted
+	b.members, b.sorted = nil, true
+	return b.z.load(members, sorted)
+}
+
+/* Replaces the elements of z with members, which are sorted first unless
+ * already known to be in ascending order. The new skiplist is built in
+ * O(N) before taking the lock, which store only holds to swap it in. */
+func (z *SortedSet[K, S]) load(members []Member[K, S], sorted bool) error {
+	if !sorted {
+		/* NaN can't be ordered, reject it before sorting. */
+		if z.nan != nil {
+			for _, m := range members {
+				if z.nan(m.Score) {
+					return ErrNaN
+				}
+			}
+		}
+		sort.Slice(members, func(i, j int) bool {
+			return z.compareMembers(members[i], members[j]) < 0
+		})
+	}
+	src := z.empty()
+	src.dict = make(map[K]S, len(members))
+	if err := src.build(members); err != nil {
+		return err
+	}
+	z.store(src)
+	return nil
+}
+
+/* Compares two elements the way the skiplist orders them, by score and
+ * then by key. */
+func (z *SortedSet[K, S]) compareMembers(a, b Member[K, S]) int {
+	if c := z.compareScore(a.Score, b.Score); c != 0 {
+		return c
+	}
+	return z.compare(a.Key, b.Key)
+}
@@ -0,0 +1,126 @@
+package zset
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestBuilder(t *testing.T) {
+	want := New[int64]()
+	for i := int64(0); i < 1000; i++ {
+		want.Set(float64(i%37), i)
+	}
+	members := make([]Member[int64, float64], 0, 1000)
+	want.Range(0, -1, func(score float64, key int64) {
+		members = append(members, Member[int64, float64]{Key: key, Score: score})
+	})
+
+	shuffled := append([]Member[int64, float64](nil), members...)
+	rand.Shuffle(len(shuffled), func(i, j int) {
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	})
+	for _, input := range [][]Member[int64, float64]{members, shuffled} {
+		z := New[int64]()
+		z.Set(1, -1)
+		b := NewBuilder(z)
+		b.Grow(len(input))
+		for _, m := range input {
+			b.Add(m.Score, m.Key)
+		}
+		if b.Len() != len(input) {
+			t.Error(b.Len())
+		}
+		sorted := b.Sorted()
+		if err := b.Build(); err != nil {
+			t.Fatal(err)
+		}
+		if b.Len() != 0 || !b.Sorted() {
+			t.Error("builder not reset")
+		}
+		if _, ok := z.GetScore(-1); ok || z.Length() != want.Length() {
+			t.Fatal(sorted, z.Length())
+		}
+		/* Ranks only work if the spans were built right. */
+		for i, m := range members {
+			rank, score := z.GetRank(m.Key, false)
+			if rank != int64(i) || score != m.Score {
+				t.Fatal(sorted, m, rank, score)
+			}
+			if key, _ := z.GetDataByRank(int64(len(members)-1-i), true); key != m.Key {
+				t.Fatal(sorted, m, key)
+			}
+		}
+		if n := z.RemoveRangeByRank(10, 20, nil); n != 11 {
+			t.Error(n)
+		}
+		z.Set(-1, 5000)
+		if rank, _ := z.GetRank(5000, false); rank != 0 {
+			t.Error(rank)
+		}
+	}
+}
+
+func TestBuilderErrors(t *testing.T) {
+	z := New[string]()
+	z.Set(1, "kept")
+	b := NewBuilder(z)
+
+	b.Add(1, "a")
+	b.Add(2, "b")
+	b.Add(3, "a")
+	if !b.Sorted() {
+		t.Error("not sorted")
+	}
+	if err := b.Build(); err != ErrDuplicateKey {
+		t.Error(err)
+	}
+	b.Add(2, "a")
+	b.Add(1, "b")
+	b.Add(2, "a")
+	if err := b.Build(); err != ErrDuplicateKey {
+		t.Error(err)
+	}
+	for _, sorted := range []bool{true, false} {
+		b.Add(1, "a")
+		b.Add(math.NaN(), "b")
+		if !sorted {
+			b.Add(0, "c")
+		}
+		if err := b.Build(); err != ErrNaN {
+			t.Error(sorted, err)
+		}
+	}
+	if score, ok := z.GetScore("kept"); !ok || score != 1 || z.Length() != 1 {
+		t.Error("set changed")
+	}
+
+	/* An empty build empties the set. */
+	if err := b.Build(); err != nil || z.Length() != 0 {
+		t.Error(err, z.Length())
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("no panic")
+		}
+	}()
+	NewBuilder(&SortedSet[string, float64]{})
+}
+
+func BenchmarkBuilder(b *testing.B) {
+	b.StopTimer()
+	members := make([]Member[int64, float64], b.N)
+	for i := range members {
+		members[i] = Member[int64, float64]{Key: int64(i), Score: float64(i / 4)}
+	}
+	z := New[int64]()
+	builder := NewBuilder(z)
+	builder.Grow(b.N)
+
+	b.StartTimer()
+	for _, m := range members {
+		builder.Add(m.Score, m.Key)
+	}
+	builder.Build()
+}
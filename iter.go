@@ -0,0 +1,145 @@
+//go:build go1.23
+
+package zset
+
+import "iter"
+
+/*-----------------------------------------------------------------------------
+ * Range-over-func iterators
+ *
+ * They are built on Iterator, so the read lock is only held while moving
+ * from an element to the next one, never while the loop body runs, and the
+ * body may modify the set. Every step sees the set as it is at that moment:
+ * elements inserted ahead of the current position will be visited, elements
+ * removed ahead of it will not, and an element whose score changes may be
+ * visited twice or not at all. Elements which are not modified are visited
+ * exactly once, in order.
+ *----------------------------------------------------------------------------*/
+
+// All returns an iterator over the keys and scores of z, in ascending order.
+func (z *SortedSet[K, S]) All() iter.Seq2[K, S] {
+	return z.seq(false, (*Iterator[K, S]).First, nil)
+}
+
+// Backward returns an iterator over the keys and scores of z,
+// in descending order.
+func (z *SortedSet[K, S]) Backward() iter.Seq2[K, S] {
+	return z.seq(true, (*Iterator[K, S]).Last, nil)
+}
+
+// ByScore returns an iterator over the elements with a score in r,
+// in ascending order.
+func (z *SortedSet[K, S]) ByScore(r ScoreRange[S]) iter.Seq2[K, S] {
+	seek, inRange := z.byScore(r)
+	return z.seq(false, seek, inRange)
+}
+
+// ByLex returns an iterator over the elements with a key in r,
+// in ascending order. Like RangeByLex, it expects all the elements
+// to have the same score.
+func (z *SortedSet[K, S]) ByLex(r LexRange[K]) iter.Seq2[K, S] {
+	seek, inRange := z.byLex(r)
+	return z.seq(false, seek, inRange)
+}
+
+// FromRank returns an iterator over the elements from the 0-based rank on,
+// in ascending order. A negative rank counts from the highest score,
+// -1 being the last element.
+func (z *SortedSet[K, S]) FromRank(rank int64) iter.Seq2[K, S] {
+	return z.seq(false, fromRank[K, S](rank), nil)
+}
+
+func (z *SortedSet[K, S]) byScore(r ScoreRange[S]) (func(*Iterator[K, S]) bool, func(K, S) bool) {
+	ran := r.spec(z.nan)
+	return func(it *Iterator[K, S]) bool {
+			return it.seek(func(zsl *skipList[K, S]) (*skipListNode[K, S], int64) {
+				return zsl.zslFirstInRange(ran), -1
+			})
+		}, func(key K, score S) bool {
+			return zslValueLteMax(z.compareScore, score, ran)
+		}
+}
+
+func (z *SortedSet[K, S]) byLex(r LexRange[K]) (func(*Iterator[K, S]) bool, func(K, S) bool) {
+	ran := r.spec()
+	return func(it *Iterator[K, S]) bool {
+			return it.seek(func(zsl *skipList[K, S]) (*skipListNode[K, S], int64) {
+				return zsl.zslFirstInLexRange(ran), -1
+			})
+		}, func(key K, score S) bool {
+			return zslLexValueLteMax(z.compare, key, ran)
+		}
+}
+
+func fromRank[K comparable, S any](rank int64) func(*Iterator[K, S]) bool {
+	return func(it *Iterator[K, S]) bool {
+		return it.seek(func(zsl *skipList[K, S]) (*skipListNode[K, S], int64) {
+			r := rank
+			if r < 0 {
+				r += zsl.length
+				if r < 0 {
+					r = 0
+				}
+			}
+			if r >= zsl.length {
+				return nil, -1
+			}
+			return zsl.zslGetElementByRank(uint64(r + 1)), r
+		})
+	}
+}
+
+func (z *SortedSet[K, S]) seq(reverse bool, seek func(*Iterator[K, S]) bool, inRange func(K, S) bool) iter.Seq2[K, S] {
+	return func(yield func(K, S) bool) {
+		it := z.Iterator()
+		for ok := seek(it); ok; ok = it.step(reverse) {
+			if inRange != nil && !inRange(it.key, it.score) {
+				return
+			}
+			if !yield(it.key, it.score) {
+				return
+			}
+		}
+	}
+}
+
+// All is SortedSet.All with the values.
+func (m *SortedMap[K, S, V]) All() iter.Seq[Entry[K, S, V]] {
+	return m.seq(false, (*Iterator[K, S]).First, nil)
+}
+
+// Backward is SortedSet.Backward with the values.
+func (m *SortedMap[K, S, V]) Backward() iter.Seq[Entry[K, S, V]] {
+	return m.seq(true, (*Iterator[K, S]).Last, nil)
+}
+
+// ByScore is SortedSet.ByScore with the values.
+func (m *SortedMap[K, S, V]) ByScore(r ScoreRange[S]) iter.Seq[Entry[K, S, V]] {
+	seek, inRange := m.byScore(r)
+	return m.seq(false, seek, inRange)
+}
+
+// ByLex is SortedSet.ByLex with the values.
+func (m *SortedMap[K, S, V]) ByLex(r LexRange[K]) iter.Seq[Entry[K, S, V]] {
+	seek, inRange := m.byLex(r)
+	return m.seq(false, seek, inRange)
+}
+
+// FromRank is SortedSet.FromRank with the values.
+func (m *SortedMap[K, S, V]) FromRank(rank int64) iter.Seq[Entry[K, S, V]] {
+	return m.seq(false, fromRank[K, S](rank), nil)
+}
+
+func (m *SortedMap[K, S, V]) seq(reverse bool, seek func(*Iterator[K, S]) bool, inRange func(K, S) bool) iter.Seq[Entry[K, S, V]] {
+	return func(yield func(Entry[K, S, V]) bool) {
+		it := m.Iterator()
+		for ok := seek(it.Iterator); ok; ok = it.step(reverse) {
+			if inRange != nil && !inRange(it.key, it.score) {
+				return
+			}
+			if !yield(Entry[K, S, V]{Key: it.key, Score: it.score, Value: it.value}) {
+				return
+			}
+		}
+	}
+}
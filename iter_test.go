@@ -0,0 +1,136 @@
+//go:build go1.23
+
+package zset
+
+import (
+	"math"
+	"testing"
+)
+
+func TestIterators(t *testing.T) {
+	z := New[string]()
+	for i, k := range []string{"a", "b", "c", "d", "e"} {
+		z.Set(float64(i), k)
+	}
+	keys := func(seq func(func(string, float64) bool)) string {
+		all := ""
+		for k := range seq {
+			all += k
+		}
+		return all
+	}
+	if got := keys(z.All()); got != "abcde" {
+		t.Error(got)
+	}
+	if got := keys(z.Backward()); got != "edcba" {
+		t.Error(got)
+	}
+	r, _ := ParseScoreRange("(1", "3")
+	if got := keys(z.ByScore(r)); got != "cd" {
+		t.Error(got)
+	}
+	if got := keys(z.ByScore(ScoreRange[float64]{Min: 10, Max: math.Inf(1)})); got != "" {
+		t.Error(got)
+	}
+	if got := keys(z.FromRank(3)); got != "de" {
+		t.Error(got)
+	}
+	if got := keys(z.FromRank(-2)); got != "de" {
+		t.Error(got)
+	}
+	if got := keys(z.FromRank(5)); got != "" {
+		t.Error(got)
+	}
+
+	lex := New[string]()
+	for _, k := range []string{"a", "b", "c", "d"} {
+		lex.Set(0, k)
+	}
+	lr, _ := ParseLexRange("(a", "[c")
+	if got := keys(lex.ByLex(lr)); got != "bc" {
+		t.Error(got)
+	}
+
+	got := ""
+	for k, score := range z.All() {
+		if score >= 2 {
+			break
+		}
+		got += k
+	}
+	if got != "ab" {
+		t.Error(got)
+	}
+}
+
+func TestIteratorsConcurrentWrites(t *testing.T) {
+	z := New[int64]()
+	for i := int64(0); i < 10; i++ {
+		z.Set(float64(i), i)
+	}
+	visited := make([]int64, 0)
+	for k := range z.All() {
+		visited = append(visited, k)
+		switch k {
+		case 2:
+			/* Remove the current element and one ahead. */
+			z.Delete(2)
+			z.Delete(5)
+		case 6:
+			/* Insert behind and ahead. */
+			z.Set(0.5, 100)
+			z.Set(8.5, 101)
+		}
+	}
+	want := []int64{0, 1, 2, 3, 4, 6, 7, 8, 101, 9}
+	if len(visited) != len(want) {
+		t.Fatal(visited)
+	}
+	for i := range want {
+		if visited[i] != want[i] {
+			t.Fatal(visited)
+		}
+	}
+
+	/* Deleting everything from the loop body ends the iteration. */
+	n := 0
+	for k := range z.Backward() {
+		n++
+		if k == 8 {
+			z.RemoveRangeByRank(0, -1, nil)
+		}
+	}
+	if n != 3 || z.Length() != 0 {
+		t.Error(n, z.Length())
+	}
+}
+
+func TestMapIterators(t *testing.T) {
+	m := NewMap[string, int]()
+	for i, k := range []string{"a", "b", "c"} {
+		m.Set(float64(i), k, i*10)
+	}
+	sum := 0
+	for e := range m.All() {
+		sum += e.Value
+		m.Set(e.Score, e.Key, e.Value+1)
+	}
+	if sum != 30 {
+		t.Error(sum)
+	}
+	got := ""
+	for e := range m.Backward() {
+		got += e.Key
+		if v, _ := m.Get(e.Key); v != e.Value {
+			t.Error(e)
+		}
+	}
+	if got != "cba" {
+		t.Error(got)
+	}
+	for e := range m.FromRank(-1) {
+		if e.Key != "c" || e.Value != 21 {
+			t.Error(e)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package zset
+
+// Iterator is a bidirectional cursor over a SortedSet, see SortedSet.Iterator.
+//
+// It holds the read lock only while moving, so the set may be modified
+// between moves. When that happens the Iterator notices it, because it
+// remembers the version of the skiplist it saw, and the next move starts
+// from where the current key and score would be in the modified set instead
+// of following the possibly stale node. So elements removed ahead of the
+// Iterator are not visited, elements inserted ahead of it are, and elements
+// which are not modified are visited exactly once and in order. An element
+// whose score changes may be visited twice or not at all.
+type Iterator[K comparable, S any] struct {
+	z       *SortedSet[K, S]
+	zsl     *skipList[K, S]
+	version uint64
+	node    *skipListNode[K, S]
+	key     K
+	score   S
+	rank    int64 // 0-based rank of node, -1 when unknown
+	started bool
+	// visit is called with the read lock held whenever the Iterator
+	// moves to an element, it lets a MapIterator read its value.
+	visit func(key K)
+}
+
+// Iterator returns an unpositioned Iterator over z. Next moves it to the
+// first element and Prev to the last one, or it can be positioned with
+// one of the Seek methods.
+func (z *SortedSet[K, S]) Iterator() *Iterator[K, S] {
+	return &Iterator[K, S]{z: z, rank: -1}
+}
+
+// Valid reports whether the Iterator is positioned at an element.
+func (it *Iterator[K, S]) Valid() bool {
+	return it.node != nil
+}
+
+// Key returns the key of the element the Iterator is positioned at.
+func (it *Iterator[K, S]) Key() K {
+	return it.key
+}
+
+// Score returns the score of the element the Iterator is positioned at,
+// as it was when the Iterator moved there.
+func (it *Iterator[K, S]) Score() S {
+	return it.score
+}
+
+// Rank returns the current 0-based rank of the element the Iterator is
+// positioned at, in ascending order, or -1 if it is not positioned or
+// the element has been removed or updated since the Iterator moved there.
+func (it *Iterator[K, S]) Rank() int64 {
+	if it.node == nil {
+		return -1
+	}
+	it.z.lock.RLock()
+	defer it.z.lock.RUnlock()
+	zsl := it.z.zsl
+	if it.zsl == zsl && it.version == zsl.version && it.rank >= 0 {
+		return it.rank
+	}
+	score, ok := it.z.dict[it.key]
+	if !ok || it.z.compareScore(score, it.score) != 0 {
+		return -1
+	}
+	/* Still there, catch up with the current version. */
+	rank := zsl.zslGetRank(score, it.key)
+	it.zsl, it.version = zsl, zsl.version
+	it.node = zsl.zslGetElementByRank(uint64(rank))
+	it.rank = rank - 1
+	return it.rank
+}
+
+// First moves the Iterator to the element with the lowest score.
+func (it *Iterator[K, S]) First() bool {
+	return it.seek(func(zsl *skipList[K, S]) (*skipListNode[K, S], int64) {
+		return zsl.header.level[0].forward, 0
+	})
+}
+
+// Last moves the Iterator to the element with the highest score.
+func (it *Iterator[K, S]) Last() bool {
+	return it.seek(func(zsl *skipList[K, S]) (*skipListNode[K, S], int64) {
+		return zsl.tail, zsl.length - 1
+	})
+}
+
+// SeekScore moves the Iterator to the first element with a score
+// greater than or equal to score.
+func (it *Iterator[K, S]) SeekScore(score S) bool {
+	return it.seek(func(zsl *skipList[K, S]) (*skipListNode[K, S], int64) {
+		rank := int64(zsl.zslCountLess(score, false))
+		if rank >= zsl.length {
+			return nil, -1
+		}
+		return zsl.zslGetElementByRank(uint64(rank + 1)), rank
+	})
+}
+
+// SeekKey moves the Iterator to the element with the given key.
+// If there is no such element, the Iterator is no longer positioned.
+func (it *Iterator[K, S]) SeekKey(key K) bool {
+	return it.seek(func(zsl *skipList[K, S]) (*skipListNode[K, S], int64) {
+		score, ok := it.z.dict[key]
+		if !ok {
+			return nil, -1
+		}
+		rank := zsl.zslGetRank(score, key)
+		return zsl.zslGetElementByRank(uint64(rank)), rank - 1
+	})
+}
+
+// SeekRank moves the Iterator to the element with the given 0-based rank,
+// in ascending order. A negative rank counts from the highest score,
+// -1 being the last element.
+func (it *Iterator[K, S]) SeekRank(rank int64) bool {
+	return it.seek(func(zsl *skipList[K, S]) (*skipListNode[K, S], int64) {
+		if rank < 0 {
+			rank += zsl.length
+		}
+		if rank < 0 || rank >= zsl.length {
+			return nil, -1
+		}
+		return zsl.zslGetElementByRank(uint64(rank + 1)), rank
+	})
+}
+
+// Next moves the Iterator to the next element in ascending order.
+// It returns false, and the Iterator is no longer positioned, when there
+// is no such element. An unpositioned Iterator stays so, unless it has
+// never been moved, in which case Next is the same as First.
+func (it *Iterator[K, S]) Next() bool {
+	if !it.started {
+		return it.First()
+	}
+	return it.step(false)
+}
+
+// Prev moves the Iterator to the previous element in ascending order.
+// It returns false, and the Iterator is no longer positioned, when there
+// is no such element. An unpositioned Iterator stays so, unless it has
+// never been moved, in which case Prev is the same as Last.
+func (it *Iterator[K, S]) Prev() bool {
+	if !it.started {
+		return it.Last()
+	}
+	return it.step(true)
+}
+
+func (it *Iterator[K, S]) seek(find func(*skipList[K, S]) (*skipListNode[K, S], int64)) bool {
+	it.z.lock.RLock()
+	defer it.z.lock.RUnlock()
+	x, rank := find(it.z.zsl)
+	return it.moveTo(x, rank)
+}
+
+func (it *Iterator[K, S]) step(reverse bool) bool {
+	if it.node == nil {
+		return false
+	}
+	it.z.lock.RLock()
+	defer it.z.lock.RUnlock()
+	zsl := it.z.zsl
+
+	if it.zsl == zsl && it.version == zsl.version {
+		/* Nothing changed, the node is still linked. */
+		rank := int64(-1)
+		if reverse {
+			if it.rank >= 0 {
+				rank = it.rank - 1
+			}
+			return it.moveTo(it.node.backward, rank)
+		}
+		if it.rank >= 0 {
+			rank = it.rank + 1
+		}
+		return it.moveTo(it.node.level[0].forward, rank)
+	}
+	if reverse {
+		return it.moveTo(zsl.zslLastBefore(it.score, it.key), -1)
+	}
+	return it.moveTo(zsl.zslFirstAfter(it.score, it.key), -1)
+}
+
+/* Must be called with the read lock held. */
+func (it *Iterator[K, S]) moveTo(x *skipListNode[K, S], rank int64) bool {
+	zsl := it.z.zsl
+	it.started = true
+	it.zsl, it.version = zsl, zsl.version
+	it.node = x
+	if x == nil {
+		it.key, it.score, it.rank = *new(K), *new(S), -1
+		return false
+	}
+	it.key, it.score, it.rank = x.objID, x.score, rank
+	if it.visit != nil {
+		it.visit(x.objID)
+	}
+	return true
+}
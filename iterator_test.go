@@ -0,0 +1,106 @@
+package zset
+
+import "testing"
+
+func TestIterator(t *testing.T) {
+	z := New[string]()
+	for i, k := range []string{"a", "b", "c", "d", "e"} {
+		z.Set(float64(i*10), k)
+	}
+
+	it := z.Iterator()
+	if it.Valid() || it.Rank() != -1 {
+		t.Error("a new Iterator should not be positioned")
+	}
+	got := ""
+	for it.Next() {
+		got += it.Key()
+	}
+	if got != "abcde" || it.Valid() || it.Next() {
+		t.Error(got)
+	}
+
+	got = ""
+	for it = z.Iterator(); it.Prev(); {
+		got += it.Key()
+	}
+	if got != "edcba" {
+		t.Error(got)
+	}
+
+	it = z.Iterator()
+	if !it.SeekScore(15) || it.Key() != "c" || it.Score() != 20 || it.Rank() != 2 {
+		t.Error(it.Key(), it.Score(), it.Rank())
+	}
+	if !it.Prev() || it.Key() != "b" || it.Rank() != 1 {
+		t.Error(it.Key(), it.Rank())
+	}
+	if it.SeekScore(41) || it.Valid() {
+		t.Error(it.Key())
+	}
+	if !it.SeekKey("d") || it.Rank() != 3 || !it.Next() || it.Key() != "e" || it.Rank() != 4 {
+		t.Error(it.Key(), it.Rank())
+	}
+	if it.SeekKey("x") || it.Valid() {
+		t.Error(it.Key())
+	}
+	if !it.SeekRank(-1) || it.Key() != "e" || !it.SeekRank(0) || it.Key() != "a" {
+		t.Error(it.Key())
+	}
+	if it.SeekRank(5) || it.SeekRank(-6) {
+		t.Error(it.Key())
+	}
+	if !it.Last() || it.Key() != "e" || !it.First() || it.Key() != "a" {
+		t.Error(it.Key())
+	}
+}
+
+func TestIteratorConcurrentModification(t *testing.T) {
+	z := New[string]()
+	for i, k := range []string{"a", "b", "c", "d", "e"} {
+		z.Set(float64(i*10), k)
+	}
+	it := z.Iterator()
+	it.SeekKey("c")
+
+	/* Remove the current element: it keeps its key and score,
+	 * but has no rank anymore, and moves go to its old neighbours. */
+	z.Delete("c")
+	if it.Key() != "c" || it.Score() != 20 || it.Rank() != -1 {
+		t.Error(it.Key(), it.Score(), it.Rank())
+	}
+	if !it.Next() || it.Key() != "d" || it.Rank() != 2 {
+		t.Error(it.Key(), it.Rank())
+	}
+
+	/* Insert before the current element, the rank follows. */
+	z.Set(5, "f")
+	if it.Rank() != 3 {
+		t.Error(it.Rank())
+	}
+	if !it.Prev() || it.Key() != "b" || it.Rank() != 2 {
+		t.Error(it.Key(), it.Rank())
+	}
+
+	/* Remove the neighbours, they are not visited. */
+	z.Delete("a")
+	z.Delete("f")
+	z.Delete("d")
+	if it.Prev() || it.Valid() {
+		t.Error(it.Key())
+	}
+	it.SeekKey("b")
+	if !it.Next() || it.Key() != "e" || it.Rank() != 1 {
+		t.Error(it.Key(), it.Rank())
+	}
+
+	/* An updated score means the element moved. */
+	z.Set(100, "e")
+	if it.Rank() != -1 {
+		t.Error(it.Rank())
+	}
+	z.Set(40, "e")
+	if it.Rank() != 1 {
+		t.Error(it.Rank())
+	}
+}
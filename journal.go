@@ -0,0 +1,638 @@
+package zset
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/*-----------------------------------------------------------------------------
+ * Journal, an append-only log of the changes like the AOF of Redis
+ *
+ * Changes are written as RESP arrays of bulk strings, the commands which
+ * replay them:
+ *
+ *   ZADD name score key [score key ...]   elements added or updated
+ *   ZREM name key [key ...]               elements deleted, also those
+ *                                         of a range removal
+ *   ZPOPMIN name count                    also ZPOPMAX
+ *   DEL name                              all the elements replaced, by a
+ *                                         store, followed by ZADDs
+ *   MULTI ... EXEC                        around the changes of a transaction,
+ *                                         or a change taking several commands
+ *
+ * Increments are written as the ZADD of their result. Keys and scores are
+ * written as text, so only sets whose key and score types are of integer,
+ * float or string kinds can be journaled.
+ *----------------------------------------------------------------------------*/
+
+// SyncPolicy tells how often a Journal flushes what it wrote to stable
+// storage, like appendfsync does in Redis.
+type SyncPolicy uint8
+
+// Sync policies of a Journal.
+const (
+	SyncAlways   SyncPolicy = iota // After every write, before the change returns
+	SyncEverySec                   // Once per second, in the background
+	SyncNo                         // Never, it is left to the operating system
+)
+
+/* Like AOF_REWRITE_ITEMS_PER_CMD of Redis. */
+const journalItemsPerCommand = 64
+
+// ErrJournalClosed is returned by a Journal once it is closed.
+var ErrJournalClosed = errors.New("journal is closed")
+
+// Journal records the changes of the sorted sets attached to it to an
+// io.Writer, each set under its own name, so that they can be restored
+// by Replay after a restart. If the io.Writer has a Sync method, like
+// *os.File, it is called according to the SyncPolicy.
+//
+// When a write or a sync fails, the Journal stops recording, while the
+// changes keep happening in memory: Err reports the error, and Rewrite
+// starts over with another io.Writer.
+type Journal struct {
+	mu     sync.Mutex
+	w      io.Writer
+	policy SyncPolicy
+	sets   map[string]journaled
+	// dirty is true when something was written since the last sync.
+	dirty bool
+	err   error
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+/* A set attached to a Journal, see setJournal. */
+type journaled interface {
+	lockID() uint64
+	rlock()
+	runlock()
+	// appendState appends the commands recreating the set, which must
+	// be read locked.
+	appendState(b []byte) []byte
+	// replay applies args, without recording them.
+	replay(args [][]byte) error
+}
+
+// NewJournal returns a Journal writing to w.
+func NewJournal(w io.Writer, policy SyncPolicy) *Journal {
+	j := &Journal{
+		w:      w,
+		policy: policy,
+		sets:   make(map[string]journaled),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	if policy == SyncEverySec {
+		go j.syncEverySec()
+	} else {
+		close(j.done)
+	}
+	return j
+}
+
+func (j *Journal) syncEverySec() {
+	defer close(j.done)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-j.stop:
+			return
+		case <-ticker.C:
+			j.Sync()
+		}
+	}
+}
+
+// Sync flushes what was written to stable storage, if the io.Writer has
+// a Sync method.
+func (j *Journal) Sync() error {
+	j.mu.Lock()
+	if j.err != nil || !j.dirty {
+		err := j.err
+		j.mu.Unlock()
+		return err
+	}
+	j.dirty = false
+	w := j.w
+	j.mu.Unlock()
+
+	/* Not holding the lock, so that changes don't wait for the disk. */
+	err := syncWriter(w)
+	if err != nil {
+		j.mu.Lock()
+		if j.err == nil && j.w == w {
+			j.err = err
+		}
+		j.mu.Unlock()
+	}
+	return err
+}
+
+func syncWriter(w io.Writer) error {
+	if s, ok := w.(interface{ Sync() error }); ok {
+		return s.Sync()
+	}
+	return nil
+}
+
+// Err returns the error which stopped the Journal, if any.
+func (j *Journal) Err() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.err
+}
+
+// Close syncs the Journal a last time and stops it, it doesn't close the
+// io.Writer. It returns the error which stopped the Journal, if any.
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	if j.err == ErrJournalClosed {
+		j.mu.Unlock()
+		return j.err
+	}
+	close(j.stop)
+	j.mu.Unlock()
+	<-j.done
+
+	err := j.Sync()
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err == nil {
+		err = j.err
+	}
+	j.err = ErrJournalClosed
+	return err
+}
+
+/* Must be called with the locks of the sets the commands are about held,
+ * so that the journal has the changes in the order they happened. */
+func (j *Journal) write(b []byte) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.err != nil {
+		return
+	}
+	if _, err := j.w.Write(b); err != nil {
+		j.err = err
+		return
+	}
+	j.dirty = true
+	if j.policy == SyncAlways {
+		j.dirty = false
+		j.err = syncWriter(j.w)
+	}
+}
+
+// Attach makes j record the changes of z under name, from then on: z should
+// be empty, restored by Replay or followed by a Rewrite. It returns
+// ErrUnsupportedType if the keys or the scores of z are not of integer,
+// float, string or bool kinds. Only the keys and scores of a SortedMap are
+// recorded, not its values. Attach panics if z is already attached or
+// name is already taken.
+func (z *SortedSet[K, S]) Attach(j *Journal, name string) error {
+	keys, err := textCodecOf[K]()
+	if err != nil {
+		return err
+	}
+	scores, err := textCodecOf[S]()
+	if err != nil {
+		return err
+	}
+	z.lock.Lock()
+	defer z.lock.Unlock()
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if z.journal != nil {
+		panic("zset: set already attached to a journal")
+	}
+	if _, ok := j.sets[name]; ok {
+		panic("zset: journal name already taken: " + name)
+	}
+	z.journal = &setJournal[K, S]{j: j, z: z, name: name, keys: keys, scores: scores}
+	j.sets[name] = z.journal
+	return nil
+}
+
+// Rewrite writes the commands recreating the sets attached to j to w, and
+// makes j write to w from then on, like BGREWRITEAOF, though holding the
+// read locks of the sets while writing. Typically w is a new file which
+// replaces the one of the journal once Rewrite returns. It also restarts
+// a Journal stopped by an error.
+func (j *Journal) Rewrite(w io.Writer) error {
+	var sets []journaled
+	for {
+		j.mu.Lock()
+		if j.err == ErrJournalClosed {
+			j.mu.Unlock()
+			return j.err
+		}
+		sets = sets[:0]
+		for _, s := range j.sets {
+			sets = append(sets, s)
+		}
+		j.mu.Unlock()
+
+		/* Same lock order as transactions, then the journal. */
+		sort.Slice(sets, func(a, b int) bool {
+			return sets[a].lockID() < sets[b].lockID()
+		})
+		for _, s := range sets {
+			s.rlock()
+		}
+		j.mu.Lock()
+		if len(j.sets) == len(sets) {
+			break
+		}
+		/* A set was attached meanwhile. */
+		j.mu.Unlock()
+		for _, s := range sets {
+			s.runlock()
+		}
+	}
+	defer func() {
+		for _, s := range sets {
+			s.runlock()
+		}
+	}()
+	defer j.mu.Unlock()
+
+	names := make([]string, 0, len(j.sets))
+	for name := range j.sets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b []byte
+	for _, name := range names {
+		b = j.sets[name].appendState(b)
+	}
+	if _, err := w.Write(b); err != nil {
+		return err
+	}
+	if err := syncWriter(w); err != nil {
+		return err
+	}
+	j.w = w
+	j.dirty = false
+	j.err = nil
+	return nil
+}
+
+// Replay applies the commands read from r, as written by a Journal, to the
+// sets attached to j, without recording them again. It is meant to restore
+// the sets from the file of the journal when starting up. A truncated last
+// command, or transaction, as left by a crash is ignored, and commands for
+// names which are not attached are skipped. It returns ErrBadEncoding if a
+// command can't be read or applied, after applying the ones before.
+//
+// It also returns the offset in r following the last command applied, or
+// skipped: the file should be truncated to it before the journal appends
+// to it, or else the new commands would follow a partial one and could
+// not be replayed.
+func (j *Journal) Replay(r io.Reader) (int64, error) {
+	cr := &countingReader{r: r}
+	br := bufio.NewReader(cr)
+	var multi [][][]byte
+	inMulti := false
+	var offset int64
+	for {
+		args, err := readCommand(br)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			/* A transaction without EXEC is dropped too. */
+			return offset, nil
+		} else if err != nil {
+			return offset, err
+		}
+		switch cmd := string(bytes.ToUpper(args[0])); {
+		case cmd == "MULTI" && !inMulti:
+			inMulti = true
+			continue
+		case cmd == "EXEC" && inMulti:
+			for _, args := range multi {
+				if err := j.replay(args); err != nil {
+					return offset, err
+				}
+			}
+			inMulti, multi = false, nil
+		case inMulti:
+			multi = append(multi, args)
+			continue
+		default:
+			if err := j.replay(args); err != nil {
+				return offset, err
+			}
+		}
+		offset = cr.n - int64(br.Buffered())
+	}
+}
+
+/* Counts the bytes read from r, to tell the offsets of the commands. */
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (j *Journal) replay(args [][]byte) error {
+	if len(args) < 2 {
+		return ErrBadEncoding
+	}
+	j.mu.Lock()
+	s, ok := j.sets[string(args[1])]
+	j.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	return s.replay(args)
+}
+
+/* Reads an array of bulk strings. It returns io.EOF if r is at its end,
+ * and io.ErrUnexpectedEOF if it ends in the middle of the command. */
+func readCommand(r *bufio.Reader) ([][]byte, error) {
+	n, err := readRESPLength(r, '*')
+	if err != nil {
+		return nil, err
+	}
+	if n < 1 {
+		return nil, ErrBadEncoding
+	}
+	args := make([][]byte, n)
+	for i := range args {
+		l, err := readRESPLength(r, '$')
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		} else if err != nil {
+			return nil, err
+		}
+		arg := make([]byte, l+2)
+		if _, err := io.ReadFull(r, arg); err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		} else if err != nil {
+			return nil, err
+		}
+		if arg[l] != '\r' || arg[l+1] != '\n' {
+			return nil, ErrBadEncoding
+		}
+		args[i] = arg[:l]
+	}
+	return args, nil
+}
+
+func readRESPLength(r *bufio.Reader, prefix byte) (int, error) {
+	line, err := r.ReadSlice('\n')
+	if err == io.EOF && len(line) > 0 {
+		return 0, io.ErrUnexpectedEOF
+	} else if err == bufio.ErrBufferFull {
+		return 0, ErrBadEncoding
+	} else if err != nil {
+		return 0, err
+	}
+	if len(line) < 3 || line[0] != prefix || line[len(line)-2] != '\r' {
+		return 0, ErrBadEncoding
+	}
+	n, err := strconv.Atoi(string(line[1 : len(line)-2]))
+	if err != nil || n < 0 || n > 512<<20 {
+		return 0, ErrBadEncoding
+	}
+	return n, nil
+}
+
+func appendCommand(b []byte, args ...string) []byte {
+	b = append(b, '*')
+	b = strconv.AppendInt(b, int64(len(args)), 10)
+	b = append(b, "\r\n"...)
+	for _, arg := range args {
+		b = append(b, '$')
+		b = strconv.AppendInt(b, int64(len(arg)), 10)
+		b = append(b, "\r\n"...)
+		b = append(b, arg...)
+		b = append(b, "\r\n"...)
+	}
+	return b
+}
+
+/*-----------------------------------------------------------------------------
+ * Recording the changes of a set
+ *----------------------------------------------------------------------------*/
+
+type setJournal[K comparable, S any] struct {
+	j      *Journal
+	z      *SortedSet[K, S]
+	name   string
+	keys   textCodec[K]
+	scores textCodec[S]
+	// tx is true in a transaction, whose commands are kept in pending
+	// until it commits.
+	tx       bool
+	pending  []byte
+	commands int
+}
+
+func (s *setJournal[K, S]) log(args ...string) {
+	b := appendCommand(nil, args...)
+	if s.tx {
+		s.pending = append(s.pending, b...)
+		s.commands++
+		return
+	}
+	s.j.write(b)
+}
+
+/* The journal functions must be called with the write lock held, after the
+ * change they record. */
+
+func (z *SortedSet[K, S]) journalAdd(score S, key K) {
+	if s := z.journal; s != nil {
+		s.log("ZADD", s.name, s.scores.format(score), s.keys.format(key))
+	}
+}
+
+func (z *SortedSet[K, S]) journalDelete(key K) {
+	if s := z.journal; s != nil {
+		s.log("ZREM", s.name, s.keys.format(key))
+	}
+}
+
+/* Records the elements removed by a range removal as ZREMs of their keys
+ * rather than the range: over mixed scores, a lex range may select other
+ * elements once replayed, as the walk depends on the levels of the nodes. */
+func (z *SortedSet[K, S]) journalRemoved(members []Member[K, S]) {
+	if s := z.journal; s != nil && len(members) > 0 {
+		var b []byte
+		commands := 0
+		args := make([]string, 0, 2+journalItemsPerCommand)
+		for i, m := range members {
+			if len(args) == 0 {
+				args = append(args, "ZREM", s.name)
+			}
+			args = append(args, s.keys.format(m.Key))
+			if len(args) == cap(args) || i == len(members)-1 {
+				b = appendCommand(b, args...)
+				commands++
+				args = args[:0]
+			}
+		}
+		s.logCommands(b, commands)
+	}
+}
+
+func (z *SortedSet[K, S]) journalPop(count int64, reverse bool) {
+	if s := z.journal; s != nil {
+		cmd := "ZPOPMIN"
+		if reverse {
+			cmd = "ZPOPMAX"
+		}
+		s.log(cmd, s.name, strconv.FormatInt(count, 10))
+	}
+}
+
+/* Records that all the elements were replaced. */
+func (z *SortedSet[K, S]) journalStore() {
+	if s := z.journal; s != nil {
+		b := appendCommand(nil, "DEL", s.name)
+		b, commands := s.appendZAdds(b)
+		s.logCommands(b, 1+commands)
+	}
+}
+
+/* Logs several commands at once, in a MULTI ... EXEC block out of
+ * a transaction so that they are replayed all or none. */
+func (s *setJournal[K, S]) logCommands(b []byte, commands int) {
+	if s.tx {
+		s.pending = append(s.pending, b...)
+		s.commands += commands
+		return
+	}
+	s.j.write(wrapMulti(b, commands))
+}
+
+/* Appends the ZADDs of all the elements. */
+func (s *setJournal[K, S]) appendZAdds(b []byte) ([]byte, int) {
+	commands := 0
+	args := make([]string, 0, 2+2*journalItemsPerCommand)
+	for x := s.z.zsl.header.level[0].forward; x != nil; x = x.level[0].forward {
+		if len(args) == 0 {
+			args = append(args, "ZADD", s.name)
+		}
+		args = append(args, s.scores.format(x.score), s.keys.format(x.objID))
+		if len(args) == cap(args) || x.level[0].forward == nil {
+			b = appendCommand(b, args...)
+			commands++
+			args = args[:0]
+		}
+	}
+	return b, commands
+}
+
+func wrapMulti(b []byte, commands int) []byte {
+	if commands <= 1 {
+		return b
+	}
+	multi := appendCommand(nil, "MULTI")
+	multi = append(multi, b...)
+	return appendCommand(multi, "EXEC")
+}
+
+func (s *setJournal[K, S]) lockID() uint64 {
+	return s.z.id
+}
+
+func (s *setJournal[K, S]) rlock() {
+	s.z.lock.RLock()
+}
+
+func (s *setJournal[K, S]) runlock() {
+	s.z.lock.RUnlock()
+}
+
+func (s *setJournal[K, S]) appendState(b []byte) []byte {
+	b, _ = s.appendZAdds(b)
+	return b
+}
+
+func (s *setJournal[K, S]) replay(args [][]byte) error {
+	z := s.z
+	z.lock.Lock()
+	defer z.lock.Unlock()
+	z.journal = nil
+	defer func() {
+		z.journal = s
+	}()
+	if err := s.apply(string(bytes.ToUpper(args[0])), args[2:]); err != nil {
+		return err
+	}
+	z.serveBlocked()
+	return nil
+}
+
+func (s *setJournal[K, S]) apply(cmd string, args [][]byte) error {
+	z := s.z
+	switch {
+	case cmd == "ZADD" && len(args) > 0 && len(args)%2 == 0:
+		for i := 0; i < len(args); i += 2 {
+			score, err := s.scores.parse(string(args[i]))
+			if err != nil || (z.nan != nil && z.nan(score)) {
+				return ErrBadEncoding
+			}
+			key, err := s.keys.parse(string(args[i+1]))
+			if err != nil {
+				return ErrBadEncoding
+			}
+			z.set(score, key)
+		}
+	case cmd == "ZREM" && len(args) > 0:
+		for _, arg := range args {
+			key, err := s.keys.parse(string(arg))
+			if err != nil {
+				return ErrBadEncoding
+			}
+			z.del(key)
+		}
+	case (cmd == "ZPOPMIN" || cmd == "ZPOPMAX") && len(args) == 1:
+		count, err := strconv.ParseInt(string(args[0]), 10, 64)
+		if err != nil {
+			return ErrBadEncoding
+		}
+		z.pop(count, cmd == "ZPOPMAX")
+	case cmd == "DEL" && len(args) == 0:
+		z.removeRangeByRank(0, -1, false)
+	default:
+		return ErrBadEncoding
+	}
+	return nil
+}
+
+/* Writes the commands of the transactions which modified sets attached to
+ * journals, a MULTI ... EXEC block per journal. Must be called with the
+ * locks of the sets held. */
+func commitJournals[K comparable, S any](txs []*Tx[K, S]) {
+	var journals []*Journal
+	blocks := make(map[*Journal][]byte)
+	commands := make(map[*Journal]int)
+	for _, tx := range txs {
+		s := tx.z.journal
+		if s == nil || s.commands == 0 {
+			continue
+		}
+		if _, ok := blocks[s.j]; !ok {
+			journals = append(journals, s.j)
+		}
+		blocks[s.j] = append(blocks[s.j], s.pending...)
+		commands[s.j] += s.commands
+	}
+	for _, j := range journals {
+		j.write(wrapMulti(blocks[j], commands[j]))
+	}
+}
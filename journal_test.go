@@ -0,0 +1,349 @@
+package zset
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+/* Changes of every kind of command recorded. */
+func journalWorkload(t *testing.T, a, b *SortedSet[string, float64]) {
+	a.Set(1, "x")
+	a.Set(2, "y")
+	a.Set(math.Inf(1), "zinf")
+	a.IncrBy(0.5, "x")
+	a.Add(10, "x", NX)
+	a.SetMany([]Member[string, float64]{{"xp", 5}, {"xq", 5}, {"xr", 5}, {"xs", 6}})
+	a.Delete("y")
+	/* Keys in the same order as scores, so the lex range is well defined. */
+	a.RemoveRangeByLex(LexRange[string]{Min: "xp", MinEx: true, Max: "xr"}, nil)
+	a.RemoveRangeByScore(ScoreRange[float64]{Min: 6, Max: math.Inf(1), MinEx: true}, nil)
+	a.RemoveRangeByRank(-1, -1, nil)
+	a.PopMin(1)
+
+	b.Set(7, "x")
+	b.Set(8, "y")
+	err := UpdateAll([]*SortedSet[string, float64]{a, b}, func(txs []*Tx[string, float64]) error {
+		txs[0].Set(3, "moved")
+		txs[1].Delete("x")
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Update(func(tx *Tx[string, float64]) error {
+		tx.Set(100, "rolled back")
+		return errors.New("abort")
+	})
+	UnionStore(b, nil, AggregateSum, a, b)
+	b.PopMax(1)
+}
+
+func TestJournal(t *testing.T) {
+	var log bytes.Buffer
+	j := NewJournal(&log, SyncAlways)
+	a, b := New[string](), New[string]()
+	if err := a.Attach(j, "a"); err != nil {
+		t.Fatal(err)
+	}
+	b.Attach(j, "b")
+	journalWorkload(t, a, b)
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if s := log.String(); strings.Contains(s, "rolled back") || strings.Count(s, "MULTI") != 2 || strings.Contains(s, "ZREMRANGE") {
+		t.Error(s)
+	}
+	if got := dump(a); !sameMembers(got, map[string]float64{"xp": 5, "moved": 3}) || b.Length() == 0 {
+		t.Error(got, dump(b))
+	}
+
+	ra, rb := New[string](), New[string]()
+	replay := NewJournal(io.Discard, SyncNo)
+	ra.Attach(replay, "a")
+	rb.Attach(replay, "b")
+	ignored := NewOf[int, int]()
+	ignored.Attach(NewJournal(io.Discard, SyncNo), "a")
+	if _, err := replay.Replay(bytes.NewReader(log.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if !sameMembers(dump(a), dump(ra)) || !sameMembers(dump(b), dump(rb)) {
+		t.Error(dump(ra), dump(rb))
+	}
+	if ignored.Length() != 0 {
+		t.Error(ignored.Length())
+	}
+}
+
+func TestJournalRemoveRangeByLex(t *testing.T) {
+	/* Over mixed scores, the elements in a lex range depend on the levels
+	 * of the nodes, replay removes the same ones anyway. */
+	for i := 0; i < 50; i++ {
+		var log bytes.Buffer
+		j := NewJournal(&log, SyncNo)
+		z := NewMap[string, int]()
+		z.Attach(j, "z")
+		for k := 0; k < 20; k++ {
+			z.Set(float64(k%3), string(rune('a'+k)), k)
+		}
+		lr := LexRange[string]{Min: "f", Max: "p"}
+		if i%2 == 0 {
+			z.RemoveRangeByLex(lr, nil)
+		} else {
+			z.SortedSet.RemoveRangeByLex(lr, nil)
+		}
+		z.RemoveRangeByScore(ScoreRange[float64]{Min: 2, Max: 2}, nil)
+		z.RemoveRangeByRank(0, 1, nil)
+
+		replayed := New[string]()
+		r := NewJournal(io.Discard, SyncNo)
+		replayed.Attach(r, "z")
+		if _, err := r.Replay(&log); err != nil {
+			t.Fatal(err)
+		}
+		if !sameMembers(dump(z.SortedSet), dump(replayed)) {
+			t.Fatal(dump(z.SortedSet), dump(replayed))
+		}
+	}
+}
+
+func TestJournalKinds(t *testing.T) {
+	var log bytes.Buffer
+	j := NewJournal(&log, SyncNo)
+	z := NewOf[int64, int8]()
+	z.Attach(j, "ints")
+	z.Set(-128, math.MaxInt64)
+	z.Set(3, -1)
+	z.Set(5, 0)
+	z.RemoveRangeByScore(ScoreRange[int8]{Min: 4, Max: 127}, nil)
+	z.RemoveRangeByLex(LexRange[int64]{Min: -5, Max: -1, MaxEx: true}, nil)
+
+	replayed := NewOf[int64, int8]()
+	r := NewJournal(io.Discard, SyncNo)
+	replayed.Attach(r, "ints")
+	if _, err := r.Replay(&log); err != nil {
+		t.Fatal(err)
+	}
+	if score, _ := replayed.GetScore(math.MaxInt64); score != -128 || replayed.Length() != 2 {
+		t.Error(score, replayed.Length())
+	}
+
+	if err := NewComposite[string, result]().Attach(j, "composite"); err != ErrUnsupportedType {
+		t.Error(err)
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("name taken twice")
+			}
+		}()
+		NewOf[int64, int8]().Attach(j, "ints")
+	}()
+}
+
+func TestReplayTruncated(t *testing.T) {
+	var log bytes.Buffer
+	j := NewJournal(&log, SyncNo)
+	z := New[string]()
+	z.Attach(j, "z")
+	z.Set(1, "a")
+	z.Update(func(tx *Tx[string, float64]) error {
+		tx.Set(2, "b")
+		tx.Set(3, "c")
+		return nil
+	})
+	data := log.Bytes()
+	multi := bytes.Index(data, []byte("*1\r\n$5\r\nMULTI"))
+
+	/* Every prefix is accepted, the transaction only once complete. */
+	for n := 0; n <= len(data); n++ {
+		r := New[string]()
+		rj := NewJournal(io.Discard, SyncNo)
+		r.Attach(rj, "z")
+		offset, err := rj.Replay(bytes.NewReader(data[:n]))
+		if err != nil {
+			t.Fatal(n, err)
+		}
+		want, wantOffset := 0, 0
+		if n == len(data) {
+			want, wantOffset = 3, len(data)
+		} else if n >= multi {
+			want, wantOffset = 1, multi
+		}
+		if r.Length() != int64(want) || offset != int64(wantOffset) {
+			t.Fatal(n, r.Length(), offset)
+		}
+	}
+
+	for _, bad := range []string{
+		"*2\r\n$3\r\nZREM\r\n",
+		"*3\r\n$4\r\nZADD\r\n$1\r\nz\r\n$1\r\nx\r\n",
+		"*4\r\n$4\r\nZADD\r\n$1\r\nz\r\n$3\r\nnan\r\n$1\r\nx\r\n",
+		"*3\r\n$4\r\nNOPE\r\n$1\r\nz\r\n$1\r\nx\r\n",
+		"+OK\r\n",
+		"*1\r\n$3\r\nDELX\r\n",
+	} {
+		z := New[string]()
+		rj := NewJournal(io.Discard, SyncNo)
+		z.Attach(rj, "z")
+		del := "*2\r\n$3\r\nDEL\r\n$1\r\nz\r\n"
+		if offset, err := rj.Replay(strings.NewReader(del + bad + del)); err != ErrBadEncoding || offset != int64(len(del)) {
+			t.Errorf("%q %v %d", bad, err, offset)
+		}
+	}
+}
+
+func TestReplayFile(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "zset.aof")
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j := NewJournal(f, SyncAlways)
+	z := New[string]()
+	z.Attach(j, "z")
+	z.Set(1, "a")
+	z.Set(2, "b")
+	j.Close()
+	/* A crash in the middle of the last command */
+	info, _ := f.Stat()
+	if err := f.Truncate(info.Size() - 3); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	for i := 0; i < 2; i++ {
+		f, err := os.OpenFile(name, os.O_RDWR|os.O_APPEND, 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		j := NewJournal(f, SyncAlways)
+		r := New[string]()
+		r.Attach(j, "z")
+		offset, err := j.Replay(f)
+		if err != nil {
+			t.Fatal(i, err)
+		}
+		if err := f.Truncate(offset); err != nil {
+			t.Fatal(err)
+		}
+		if r.Length() != int64(i+1) {
+			t.Fatal(i, dump(r))
+		}
+		r.Set(3, "c"+strconv.Itoa(i))
+		j.Close()
+		f.Close()
+	}
+}
+
+func TestJournalRewrite(t *testing.T) {
+	var log bytes.Buffer
+	j := NewJournal(&log, SyncNo)
+	a, b := New[string](), New[string]()
+	a.Attach(j, "a")
+	b.Attach(j, "b")
+	journalWorkload(t, a, b)
+	for i := 0; i < 200; i++ {
+		a.IncrBy(1, "x")
+	}
+
+	var rewritten bytes.Buffer
+	if err := j.Rewrite(&rewritten); err != nil {
+		t.Fatal(err)
+	}
+	size := rewritten.Len()
+	if size >= log.Len() {
+		t.Error(size, log.Len())
+	}
+	a.Set(42, "after")
+	if bytes.Contains(log.Bytes(), []byte("after")) {
+		t.Error("written to the old writer")
+	}
+
+	ra, rb := New[string](), New[string]()
+	replay := NewJournal(io.Discard, SyncNo)
+	ra.Attach(replay, "a")
+	rb.Attach(replay, "b")
+	if _, err := replay.Replay(&rewritten); err != nil {
+		t.Fatal(err)
+	}
+	if !sameMembers(dump(a), dump(ra)) || !sameMembers(dump(b), dump(rb)) {
+		t.Error(dump(ra), dump(rb))
+	}
+}
+
+type syncWriterFunc struct {
+	write func(p []byte) error
+	syncs int
+}
+
+func (w *syncWriterFunc) Write(p []byte) (int, error) {
+	if err := w.write(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (w *syncWriterFunc) Sync() error {
+	w.syncs++
+	return nil
+}
+
+func TestJournalSync(t *testing.T) {
+	errFull := errors.New("disk full")
+	var written bytes.Buffer
+	w := &syncWriterFunc{write: func(p []byte) error {
+		written.Write(p)
+		return nil
+	}}
+	z := New[string]()
+	j := NewJournal(w, SyncAlways)
+	z.Attach(j, "z")
+	z.Set(1, "a")
+	z.Set(2, "b")
+	if w.syncs != 2 {
+		t.Error(w.syncs)
+	}
+
+	w.write = func([]byte) error { return errFull }
+	z.Set(3, "c")
+	if err := j.Err(); err != errFull {
+		t.Error(err)
+	}
+	w.write = func([]byte) error { return nil }
+	z.Set(4, "d")
+	if err := j.Err(); err != errFull || w.syncs != 2 {
+		t.Error(err, w.syncs)
+	}
+
+	/* Rewrite restarts the journal. */
+	next := &syncWriterFunc{write: func([]byte) error { return nil }}
+	if err := j.Rewrite(next); err != nil || j.Err() != nil {
+		t.Error(err, j.Err())
+	}
+	if err := j.Close(); err != nil {
+		t.Error(err)
+	}
+	if err := j.Close(); err != ErrJournalClosed {
+		t.Error(err)
+	}
+	z.Set(5, "e")
+
+	for _, policy := range []SyncPolicy{SyncEverySec, SyncNo} {
+		w := &syncWriterFunc{write: func([]byte) error { return nil }}
+		z := New[string]()
+		j := NewJournal(w, policy)
+		z.Attach(j, "z")
+		z.Set(1, "a")
+		z.Set(2, "b")
+		if err := j.Close(); err != nil || w.syncs != 1 {
+			t.Error(policy, err, w.syncs)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package zset
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+/*-----------------------------------------------------------------------------
+ * JSON encoding, json.Marshaler and json.Unmarshaler
+ *
+ * A SortedSet is encoded as an array of {"member": key, "score": score}
+ * objects in ascending order, or as an object mapping the keys to their
+ * scores with ObjectJSON. As JSON has no infinities, infinite float scores
+ * are encoded as the strings "+inf" and "-inf", like Redis replies them.
+ *----------------------------------------------------------------------------*/
+
+type jsonMember[K comparable, S any] struct {
+	Member K            `json:"member"`
+	Score  jsonScore[S] `json:"score"`
+}
+
+type jsonScore[S any] struct {
+	v S
+}
+
+func (s jsonScore[S]) MarshalJSON() ([]byte, error) {
+	rv := reflect.ValueOf(s.v)
+	switch rv.Kind() {
+	case reflect.Float32, reflect.Float64:
+		if f := rv.Float(); math.IsInf(f, 1) {
+			return []byte(`"+inf"`), nil
+		} else if math.IsInf(f, -1) {
+			return []byte(`"-inf"`), nil
+		}
+	}
+	return json.Marshal(s.v)
+}
+
+func (s *jsonScore[S]) UnmarshalJSON(data []byte) error {
+	rv := reflect.ValueOf(&s.v).Elem()
+	switch rv.Kind() {
+	case reflect.Float32, reflect.Float64:
+		if len(data) > 0 && data[0] == '"' {
+			var str string
+			if err := json.Unmarshal(data, &str); err != nil {
+				return err
+			}
+			switch strings.ToLower(str) {
+			case "+inf", "inf":
+				rv.SetFloat(math.Inf(1))
+			case "-inf":
+				rv.SetFloat(math.Inf(-1))
+			default:
+				return ErrNotFloat
+			}
+			return nil
+		}
+	}
+	return json.Unmarshal(data, &s.v)
+}
+
+// MarshalJSON implements json.Marshaler, see ObjectJSON for the other form.
+func (z *SortedSet[K, S]) MarshalJSON() ([]byte, error) {
+	z.lock.RLock()
+	members := make([]jsonMember[K, S], 0, z.zsl.length)
+	for x := z.zsl.header.level[0].forward; x != nil; x = x.level[0].forward {
+		members = append(members, jsonMember[K, S]{Member: x.objID, Score: jsonScore[S]{x.score}})
+	}
+	z.lock.RUnlock()
+	return json.Marshal(members)
+}
+
+// UnmarshalJSON implements json.Unmarshaler, it replaces the elements of z
+// with the ones in data, in either the array or the object form, in any
+// order. z must have been created by one of the constructors, and data
+// with the same key twice is rejected with ErrDuplicateKey.
+func (z *SortedSet[K, S]) UnmarshalJSON(data []byte) error {
+	if z.compareScore == nil {
+		return errNotCreated
+	}
+	data = bytes.TrimSpace(data)
+	if string(data) == "null" {
+		return nil
+	}
+	var members []Member[K, S]
+	if len(data) > 0 && data[0] == '{' {
+		var err error
+		if members, err = decodeJSONObject[K, S](data); err != nil {
+			return err
+		}
+	} else {
+		var items []jsonMember[K, S]
+		if err := json.Unmarshal(data, &items); err != nil {
+			return err
+		}
+		members = make([]Member[K, S], len(items))
+		for i, item := range items {
+			members[i] = Member[K, S]{Key: item.Member, Score: item.Score.v}
+		}
+	}
+
+	sorted := sort.SliceIsSorted(members, func(i, j int) bool {
+		return z.compareMembers(members[i], members[j]) < 0
+	})
+	return z.load(members, sorted)
+}
+
+// ObjectJSON encodes the SortedSet it wraps as a JSON object mapping the keys
+// to their scores, in ascending order, e.g. {"alice": 10, "bob": 12}.
+// Only keys of string, integer, float and bool kinds are supported. Decoding
+// works like for SortedSet, which accepts both forms.
+type ObjectJSON[K comparable, S any] struct {
+	*SortedSet[K, S]
+}
+
+// MarshalJSON implements json.Marshaler.
+func (o ObjectJSON[K, S]) MarshalJSON() ([]byte, error) {
+	keys, err := textCodecOf[K]()
+	if err != nil {
+		return nil, err
+	}
+	z := o.SortedSet
+	var b bytes.Buffer
+	z.lock.RLock()
+	defer z.lock.RUnlock()
+	b.WriteByte('{')
+	for x := z.zsl.header.level[0].forward; x != nil; x = x.level[0].forward {
+		if x != z.zsl.header.level[0].forward {
+			b.WriteByte(',')
+		}
+		key, err := json.Marshal(keys.format(x.objID))
+		if err != nil {
+			return nil, err
+		}
+		score, err := jsonScore[S]{x.score}.MarshalJSON()
+		if err != nil {
+			return nil, err
+		}
+		b.Write(key)
+		b.WriteByte(':')
+		b.Write(score)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+/* The members are decoded one by one, as decoding into a map would
+ * silently keep only the last of duplicate keys. */
+func decodeJSONObject[K comparable, S any](data []byte) ([]Member[K, S], error) {
+	keys, err := textCodecOf[K]()
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	var members []Member[K, S]
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		key, err := keys.parse(t.(string))
+		if err != nil {
+			return nil, err
+		}
+		var score jsonScore[S]
+		if err := dec.Decode(&score); err != nil {
+			return nil, err
+		}
+		members = append(members, Member[K, S]{Key: key, Score: score.v})
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	return members, nil
+}
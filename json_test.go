@@ -0,0 +1,123 @@
+package zset
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+var (
+	_ json.Marshaler   = New[string]()
+	_ json.Unmarshaler = New[string]()
+	_ json.Marshaler   = ObjectJSON[string, float64]{}
+)
+
+func TestMarshalJSON(t *testing.T) {
+	z := New[string]()
+	z.Set(2, "b")
+	z.Set(1.5, "a")
+	z.Set(math.Inf(1), "+inf")
+	z.Set(math.Inf(-1), "-inf")
+	data, err := json.Marshal(z)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `[{"member":"-inf","score":"-inf"},{"member":"a","score":1.5},{"member":"b","score":2},{"member":"+inf","score":"+inf"}]`
+	if string(data) != want {
+		t.Error(string(data))
+	}
+
+	y := New[string]()
+	y.Set(1, "old")
+	if err := json.Unmarshal(data, y); err != nil {
+		t.Fatal(err)
+	}
+	if y.Length() != 4 {
+		t.Error(y.Length())
+	}
+	if _, ok := y.GetScore("old"); ok {
+		t.Error("old element kept")
+	}
+	if rank, score := y.GetRank("+inf", false); rank != 3 || !math.IsInf(score, 1) {
+		t.Error(rank, score)
+	}
+
+	data, err = json.Marshal(ObjectJSON[string, float64]{z})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = `{"-inf":"-inf","a":1.5,"b":2,"+inf":"+inf"}`
+	if string(data) != want {
+		t.Error(string(data))
+	}
+	y = New[string]()
+	if err := json.Unmarshal(data, &ObjectJSON[string, float64]{y}); err != nil {
+		t.Fatal(err)
+	}
+	if rank, score := y.GetRank("-inf", false); rank != 0 || !math.IsInf(score, -1) {
+		t.Error(rank, score)
+	}
+}
+
+func TestMarshalJSONLargeKeys(t *testing.T) {
+	z := NewOf[int64, int64]()
+	z.Set(1, math.MaxInt64)
+	z.Set(1, math.MaxInt64-1)
+	z.Set(math.MinInt64, 0)
+
+	for _, v := range []interface{}{z, ObjectJSON[int64, int64]{z}} {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		y := NewOf[int64, int64]()
+		if err := json.Unmarshal(data, y); err != nil {
+			t.Fatal(string(data), err)
+		}
+		if rank, _ := y.GetRank(math.MaxInt64, false); rank != 2 || y.Length() != 3 {
+			t.Error(string(data), rank, y.Length())
+		}
+		if score, _ := y.GetScore(0); score != math.MinInt64 {
+			t.Error(string(data), score)
+		}
+	}
+
+	u := NewOf[uint64, float64]()
+	u.Set(1, math.MaxUint64)
+	data, _ := json.Marshal(ObjectJSON[uint64, float64]{u})
+	if string(data) != `{"18446744073709551615":1}` {
+		t.Error(string(data))
+	}
+}
+
+func TestUnmarshalJSONErrors(t *testing.T) {
+	z := New[string]()
+	z.Set(1, "a")
+	for _, data := range []string{
+		`[{"member":"x","score":1},{"member":"x","score":2}]`,
+		`{"x":1,"y":2,"x":3}`,
+	} {
+		if err := json.Unmarshal([]byte(data), z); err != ErrDuplicateKey {
+			t.Error(data, err)
+		}
+	}
+	for _, data := range []string{
+		`[{"member":"x","score":"nope"}]`,
+		`{"x":true}`,
+		`{"x":1`,
+		`"x"`,
+	} {
+		if err := json.Unmarshal([]byte(data), z); err == nil {
+			t.Error(data)
+		}
+	}
+	if z.Length() != 1 {
+		t.Error(z.Length())
+	}
+	if err := json.Unmarshal([]byte(`{"1.5":1}`), NewOf[int, float64]()); err == nil {
+		t.Error("bad key accepted")
+	}
+	if err := json.Unmarshal([]byte(`[]`), new(SortedSet[string, float64])); err == nil {
+		t.Error("zero SortedSet decoded")
+	}
+}
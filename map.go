@@ -0,0 +1,433 @@
+package zset
+
+import (
+	"context"
+	"math/rand"
+)
+
+/*-----------------------------------------------------------------------------
+ * Sorted set with a value attached to every element
+ *----------------------------------------------------------------------------*/
+
+// SortedMap is a SortedSet which stores a value along with the score of
+// every element. The values are guarded by the lock of the set, so they
+// change atomically with the elements.
+//
+// The methods of SortedSet which return elements are shadowed by versions
+// returning the values too. The other ones, e.g. Delete or IncrBy, are
+// promoted. Elements removed by them, or by free functions like MPop, lose
+// their values, and elements they add have the zero value.
+type SortedMap[K comparable, S any, V any] struct {
+	*SortedSet[K, S]
+	values map[K]V
+}
+
+// Entry is an element of a SortedMap along with its score and value.
+type Entry[K comparable, S any, V any] struct {
+	Key   K
+	Score S
+	Value V
+}
+
+// NewMap creates a new SortedMap with float64 scores, see New.
+func NewMap[K Key, V any]() *SortedMap[K, float64, V] {
+	return MapOf[V](New[K]())
+}
+
+// MapOf returns a SortedMap storing values of type V in z, so it can be
+// used with any of the constructors of SortedSet. The elements already
+// in z have the zero value. z must not be given to MapOf twice.
+func MapOf[V any, K comparable, S any](z *SortedSet[K, S]) *SortedMap[K, S, V] {
+	m := &SortedMap[K, S, V]{SortedSet: z, values: make(map[K]V)}
+	z.lock.Lock()
+	defer z.lock.Unlock()
+	if z.onRemove != nil {
+		panic("zset: the set already has values")
+	}
+	z.onRemove = func(key K) {
+		delete(m.values, key)
+	}
+	return m
+}
+
+// Set adds or updates an element with its value, see SortedSet.Set.
+func (m *SortedMap[K, S, V]) Set(score S, key K, value V) error {
+	if m.nan != nil && m.nan(score) {
+		return ErrNaN
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	/* Store the value first, a blocked pop may take the element. */
+	m.values[key] = value
+	if m.set(score, key) == Added {
+		m.serveBlocked()
+	}
+	return nil
+}
+
+// SetMany is SortedSet.SetMany with the values.
+func (m *SortedMap[K, S, V]) SetMany(entries []Entry[K, S, V]) ([]AddResult, error) {
+	if m.nan != nil {
+		for _, e := range entries {
+			if m.nan(e.Score) {
+				return nil, ErrNaN
+			}
+		}
+	}
+	results := make([]AddResult, len(entries))
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for i, e := range entries {
+		m.values[e.Key] = e.Value
+		results[i] = m.set(e.Score, e.Key)
+	}
+	m.serveBlocked()
+	return results, nil
+}
+
+// Get returns the value of an element, ok is false if there is none.
+func (m *SortedMap[K, S, V]) Get(key K) (value V, ok bool) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	if _, ok = m.dict[key]; !ok {
+		return value, false
+	}
+	return m.values[key], true
+}
+
+// GetDataByRank is SortedSet.GetDataByRank with the value.
+func (m *SortedMap[K, S, V]) GetDataByRank(rank int64, reverse bool) (key K, score S, value V) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	key, score = m.getDataByRank(rank, reverse)
+	return key, score, m.values[key]
+}
+
+// LookupByRank is SortedSet.LookupByRank with the value.
+func (m *SortedMap[K, S, V]) LookupByRank(rank int64, reverse bool) (key K, score S, value V, err error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	n := m.nodeByRank(rank, reverse)
+	if n == nil {
+		return key, score, value, ErrOutOfRange
+	}
+	return n.objID, n.score, m.values[n.objID], nil
+}
+
+// Range is SortedSet.Range with the values.
+func (m *SortedMap[K, S, V]) Range(start, end int64, f func(S, K, V)) {
+	m.snapshot(func(add func(S, K)) {
+		m.commonRange(start, end, false, add)
+	}, f)
+}
+
+// RevRange is SortedSet.RevRange with the values.
+func (m *SortedMap[K, S, V]) RevRange(start, end int64, f func(S, K, V)) {
+	m.snapshot(func(add func(S, K)) {
+		m.commonRange(start, end, true, add)
+	}, f)
+}
+
+// RangeByScore is SortedSet.RangeByScore with the values.
+func (m *SortedMap[K, S, V]) RangeByScore(r ScoreRange[S], offset, count int64, f func(S, K, V)) {
+	m.snapshot(func(add func(S, K)) {
+		m.commonRangeByScore(r.spec(m.nan), offset, count, false, add)
+	}, f)
+}
+
+// RevRangeByScore is SortedSet.RevRangeByScore with the values.
+func (m *SortedMap[K, S, V]) RevRangeByScore(r ScoreRange[S], offset, count int64, f func(S, K, V)) {
+	m.snapshot(func(add func(S, K)) {
+		m.commonRangeByScore(r.spec(m.nan), offset, count, true, add)
+	}, f)
+}
+
+// RangeByLex is SortedSet.RangeByLex with the values.
+func (m *SortedMap[K, S, V]) RangeByLex(r LexRange[K], offset, count int64, f func(S, K, V)) {
+	m.snapshot(func(add func(S, K)) {
+		m.commonRangeByLex(r.spec(), offset, count, false, add)
+	}, f)
+}
+
+// RevRangeByLex is SortedSet.RevRangeByLex with the values.
+func (m *SortedMap[K, S, V]) RevRangeByLex(r LexRange[K], offset, count int64, f func(S, K, V)) {
+	m.snapshot(func(add func(S, K)) {
+		m.commonRangeByLex(r.spec(), offset, count, true, add)
+	}, f)
+}
+
+// snapshot collects the elements passed to add by walk under the read
+// lock, along with their values, and calls f with them after unlocking.
+func (m *SortedMap[K, S, V]) snapshot(walk func(add func(S, K)), f func(S, K, V)) {
+	m.lock.RLock()
+	entries := m.collect(walk)
+	m.lock.RUnlock()
+
+	for _, e := range entries {
+		f(e.Score, e.Key, e.Value)
+	}
+}
+
+/* Must be called with the lock held. */
+func (m *SortedMap[K, S, V]) collect(walk func(add func(S, K))) []Entry[K, S, V] {
+	entries := make([]Entry[K, S, V], 0)
+	walk(func(score S, key K) {
+		entries = append(entries, Entry[K, S, V]{Key: key, Score: score, Value: m.values[key]})
+	})
+	return entries
+}
+
+/* Must be called with the lock held. */
+func (m *SortedMap[K, S, V]) entries(members []Member[K, S]) []Entry[K, S, V] {
+	entries := make([]Entry[K, S, V], len(members))
+	for i, member := range members {
+		entries[i] = Entry[K, S, V]{Key: member.Key, Score: member.Score, Value: m.values[member.Key]}
+	}
+	return entries
+}
+
+// MapTx is a Tx over a SortedMap, which also reads and sets the values.
+// Like the ones of SortedSet, the methods of Tx which add elements give
+// them the zero value.
+type MapTx[K comparable, S any, V any] struct {
+	*Tx[K, S]
+	m *SortedMap[K, S, V]
+	// undo keeps the value before the transaction of every key whose
+	// value was set.
+	undo map[K]mapUndo[V]
+}
+
+type mapUndo[V any] struct {
+	value V
+	ok    bool
+}
+
+// Update is SortedSet.Update with a MapTx, the values set through it are
+// rolled back along with the elements.
+func (m *SortedMap[K, S, V]) Update(fn func(tx *MapTx[K, S, V]) error) error {
+	return m.SortedSet.Update(func(tx *Tx[K, S]) error {
+		mtx := &MapTx[K, S, V]{Tx: tx, m: m, undo: make(map[K]mapUndo[V])}
+		tx.onRollback = mtx.rollback
+		return fn(mtx)
+	})
+}
+
+// View is SortedSet.View with a MapTx.
+func (m *SortedMap[K, S, V]) View(fn func(tx *MapTx[K, S, V]) error) error {
+	return m.SortedSet.View(func(tx *Tx[K, S]) error {
+		return fn(&MapTx[K, S, V]{Tx: tx, m: m})
+	})
+}
+
+// Get is SortedMap.Get in a transaction.
+func (tx *MapTx[K, S, V]) Get(key K) (value V, ok bool) {
+	if _, ok = tx.z.dict[key]; !ok {
+		return value, false
+	}
+	return tx.m.values[key], true
+}
+
+// Set is SortedMap.Set in a transaction.
+func (tx *MapTx[K, S, V]) Set(score S, key K, value V) error {
+	tx.mustWrite()
+	if tx.z.nan != nil && tx.z.nan(score) {
+		return ErrNaN
+	}
+	tx.saveValue(key)
+	tx.m.values[key] = value
+	return tx.Tx.Set(score, key)
+}
+
+// SetValue sets the value of an element without changing its score.
+// It returns false if there is no such element.
+func (tx *MapTx[K, S, V]) SetValue(key K, value V) bool {
+	tx.mustWrite()
+	if _, ok := tx.z.dict[key]; !ok {
+		return false
+	}
+	tx.saveValue(key)
+	tx.m.values[key] = value
+	return true
+}
+
+/* Must be called before the first change of the value of key. */
+func (tx *MapTx[K, S, V]) saveValue(key K) {
+	if _, ok := tx.undo[key]; ok {
+		return
+	}
+	value, ok := tx.m.values[key]
+	tx.undo[key] = mapUndo[V]{value: value, ok: ok}
+}
+
+func (tx *MapTx[K, S, V]) rollback() {
+	for key, u := range tx.undo {
+		if u.ok {
+			tx.m.values[key] = u.value
+		} else {
+			delete(tx.m.values, key)
+		}
+	}
+}
+
+// RemoveRangeByScore is SortedSet.RemoveRangeByScore with the values.
+func (m *SortedMap[K, S, V]) RemoveRangeByScore(r ScoreRange[S], f func(S, K, V)) int64 {
+	ran := r.spec(m.nan)
+	return m.remove(func(add func(S, K)) {
+		m.commonRangeByScore(ran, 0, -1, false, add)
+	}, func(entries []Entry[K, S, V]) uint64 {
+		return m.zsl.zslDeleteRangeByScore(ran, m.dict)
+	}, f)
+}
+
+// RemoveRangeByLex is SortedSet.RemoveRangeByLex with the values.
+func (m *SortedMap[K, S, V]) RemoveRangeByLex(r LexRange[K], f func(S, K, V)) int64 {
+	ran := r.spec()
+	return m.remove(func(add func(S, K)) {
+		m.commonRangeByLex(ran, 0, -1, false, add)
+	}, m.deleteRun, f)
+}
+
+// RemoveRangeByRank is SortedSet.RemoveRangeByRank with the values.
+func (m *SortedMap[K, S, V]) RemoveRangeByRank(start, end int64, f func(S, K, V)) int64 {
+	return m.remove(func(add func(S, K)) {
+		m.commonRange(start, end, false, add)
+	}, m.deleteRun, f)
+}
+
+/* Deletes the elements of entries, which were collected walking the level
+ * 0 of the skiplist and are consecutive. */
+func (m *SortedMap[K, S, V]) deleteRun(entries []Entry[K, S, V]) uint64 {
+	if len(entries) == 0 {
+		return 0
+	}
+	first := Member[K, S]{Key: entries[0].Key, Score: entries[0].Score}
+	return m.SortedSet.deleteRun(first, int64(len(entries)))
+}
+
+// remove collects the elements passed to add by walk with their values,
+// deletes them with del, all under the write lock, and calls f with them.
+func (m *SortedMap[K, S, V]) remove(walk func(add func(S, K)), del func([]Entry[K, S, V]) uint64, f func(S, K, V)) int64 {
+	m.lock.Lock()
+	entries := m.collect(walk)
+	removed := del(entries)
+	for _, e := range entries {
+		delete(m.values, e.Key)
+	}
+	if m.journal != nil {
+		members := make([]Member[K, S], len(entries))
+		for i, e := range entries {
+			members[i] = Member[K, S]{Key: e.Key, Score: e.Score}
+		}
+		m.journalRemoved(members)
+	}
+	m.lock.Unlock()
+
+	if f != nil {
+		for _, e := range entries {
+			f(e.Score, e.Key, e.Value)
+		}
+	}
+	return int64(removed)
+}
+
+// PopMin is SortedSet.PopMin with the values.
+func (m *SortedMap[K, S, V]) PopMin(count int64) []Entry[K, S, V] {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.pop(count, false)
+}
+
+// PopMax is SortedSet.PopMax with the values.
+func (m *SortedMap[K, S, V]) PopMax(count int64) []Entry[K, S, V] {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.pop(count, true)
+}
+
+// PopOne is SortedSet.PopOne with the value.
+func (m *SortedMap[K, S, V]) PopOne(reverse bool) (key K, score S, value V, err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	entries := m.pop(1, reverse)
+	if len(entries) == 0 {
+		return key, score, value, ErrEmpty
+	}
+	return entries[0].Key, entries[0].Score, entries[0].Value, nil
+}
+
+/* Must be called with the write lock held. */
+func (m *SortedMap[K, S, V]) pop(count int64, reverse bool) []Entry[K, S, V] {
+	if count <= 0 {
+		return nil
+	}
+	/* Read the values first, popping drops them. */
+	entries := m.collect(func(add func(S, K)) {
+		m.commonRange(0, count-1, reverse, add)
+	})
+	m.SortedSet.pop(count, reverse)
+	return entries
+}
+
+// BlockingPopMin is SortedSet.BlockingPopMin with the values.
+func (m *SortedMap[K, S, V]) BlockingPopMin(ctx context.Context, count int64) ([]Entry[K, S, V], error) {
+	return m.blockingPop(ctx, count, false)
+}
+
+// BlockingPopMax is SortedSet.BlockingPopMax with the values.
+func (m *SortedMap[K, S, V]) BlockingPopMax(ctx context.Context, count int64) ([]Entry[K, S, V], error) {
+	return m.blockingPop(ctx, count, true)
+}
+
+func (m *SortedMap[K, S, V]) blockingPop(ctx context.Context, count int64, reverse bool) ([]Entry[K, S, V], error) {
+	if count <= 0 {
+		return nil, nil
+	}
+	var entries []Entry[K, S, V]
+	_, err := blockingPop(ctx, []*SortedSet[K, S]{m.SortedSet}, func(*SortedSet[K, S]) {
+		entries = m.pop(count, reverse)
+	})
+	return entries, err
+}
+
+// RandMember is SortedSet.RandMember with the values.
+func (m *SortedMap[K, S, V]) RandMember(count int64, r *rand.Rand) ([]Entry[K, S, V], error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	members, err := m.randMember(count, r)
+	if err != nil {
+		return nil, err
+	}
+	return m.entries(members), nil
+}
+
+// Scan is SortedSet.Scan with the values.
+func (m *SortedMap[K, S, V]) Scan(cursor ScanCursor[K, S], count int64, match string) (ScanCursor[K, S], []Entry[K, S, V]) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	cursor, members := m.scan(cursor, count, match)
+	return cursor, m.entries(members)
+}
+
+// MapIterator is an Iterator over a SortedMap, which also tells the values.
+type MapIterator[K comparable, S any, V any] struct {
+	*Iterator[K, S]
+	value V
+}
+
+// Iterator is SortedSet.Iterator with the values.
+func (m *SortedMap[K, S, V]) Iterator() *MapIterator[K, S, V] {
+	it := &MapIterator[K, S, V]{Iterator: m.SortedSet.Iterator()}
+	it.visit = func(key K) {
+		it.value = m.values[key]
+	}
+	return it
+}
+
+// Value returns the value of the element the MapIterator is positioned at,
+// as it was when the MapIterator moved there.
+func (it *MapIterator[K, S, V]) Value() V {
+	if !it.Valid() {
+		return *new(V)
+	}
+	return it.value
+}
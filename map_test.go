@@ -0,0 +1,211 @@
+package zset
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestSortedMap(t *testing.T) {
+	m := NewMap[string, int]()
+	m.Set(1, "a", 10)
+	m.Set(2, "b", 20)
+	m.Set(3, "c", 30)
+	m.Set(4, "d", 40)
+	m.Set(5, "b", 21)
+
+	if err := m.Set(math.NaN(), "x", 1); err != ErrNaN || len(m.values) != 4 {
+		t.Error(err, m.values)
+	}
+	if v, ok := m.Get("b"); !ok || v != 21 {
+		t.Error(v, ok)
+	}
+	if k, score, v := m.GetDataByRank(0, true); k != "b" || score != 5 || v != 21 {
+		t.Error(k, score, v)
+	}
+	values := 0
+	m.Range(0, -1, func(score float64, k string, v int) {
+		values += v
+	})
+	if values != 101 {
+		t.Error(values)
+	}
+	got := ""
+	m.RevRangeByScore(ScoreRange[float64]{Min: 2, Max: 5}, 0, -1, func(score float64, k string, v int) {
+		got += k
+	})
+	if got != "bdc" {
+		t.Error(got)
+	}
+
+	/* Promoted methods drop the values. */
+	if !m.Delete("a") {
+		t.Error("a not deleted")
+	}
+	if _, ok := m.Get("a"); ok || len(m.values) != 3 {
+		t.Error(m.values)
+	}
+	m.IncrBy(1, "e")
+	if v, ok := m.Get("e"); !ok || v != 0 {
+		t.Error(v, ok)
+	}
+
+	removed := 0
+	n := m.RemoveRangeByRank(1, 2, func(score float64, k string, v int) {
+		removed += v
+	})
+	if n != 2 || removed != 70 || len(m.values) != 1 {
+		t.Error(n, removed, m.values)
+	}
+	if k, score, v, err := m.LookupByRank(0, true); k != "b" || score != 5 || v != 21 || err != nil {
+		t.Error(k, score, v, err)
+	}
+	if _, _, _, err := m.LookupByRank(2, true); err != ErrOutOfRange {
+		t.Error(err)
+	}
+	entries := m.PopMax(5)
+	if len(entries) != 2 || entries[0] != (Entry[string, float64, int]{"b", 5, 21}) {
+		t.Error(entries)
+	}
+	if m.Length() != 0 || len(m.values) != 0 {
+		t.Error(m.Length(), m.values)
+	}
+	if _, _, _, err := m.PopOne(false); err != ErrEmpty {
+		t.Error(err)
+	}
+	m.Set(1, "z", 26)
+	if k, _, v, err := m.PopOne(false); k != "z" || v != 26 || err != nil || len(m.values) != 0 {
+		t.Error(k, v, err, m.values)
+	}
+}
+
+func TestSortedMapRemove(t *testing.T) {
+	m := MapOf[string](NewOf[int, int]())
+	for i := 0; i < 10; i++ {
+		m.Set(i, i, string(rune('a'+i)))
+	}
+	got := ""
+	n := m.RemoveRangeByScore(ScoreRange[int]{Min: 2, Max: 4}, func(score int, k int, v string) {
+		got += v
+	})
+	if n != 3 || got != "cde" {
+		t.Error(n, got)
+	}
+	if n := m.SortedSet.RemoveRangeByRank(0, 0, nil); n != 1 {
+		t.Error(n)
+	}
+	MPop(1, true, m.SortedSet)
+	for _, k := range []int{0, 2, 3, 4, 9} {
+		if _, ok := m.values[k]; ok {
+			t.Error(k, "still has a value")
+		}
+	}
+	if len(m.values) != 5 {
+		t.Error(m.values)
+	}
+	DiffStore(m.SortedSet, m.SortedSet, NewOf[int, int]())
+	if len(m.values) != 5 {
+		t.Error(m.values)
+	}
+	InterStore(m.SortedSet, nil, AggregateSum, m.SortedSet)
+	if len(m.values) != 5 {
+		t.Error(m.values)
+	}
+	other := NewOf[int, int]()
+	other.Set(0, 5)
+	InterStore(m.SortedSet, nil, AggregateSum, m.SortedSet, other)
+	if v, _ := m.Get(5); len(m.values) != 1 || v != "f" {
+		t.Error(m.values)
+	}
+
+	entries, _ := m.RandMember(-3, rand.New(rand.NewSource(1)))
+	for _, e := range entries {
+		if e.Value != "f" {
+			t.Error(e)
+		}
+	}
+	if _, entries := m.Scan(ScanCursor[int, int]{}, 10, ""); len(entries) != 1 || entries[0].Value != "f" {
+		t.Error(entries)
+	}
+}
+
+func TestSortedMapRemoveByLexMixedScores(t *testing.T) {
+	lr, _ := ParseLexRange("[k3", "(k7")
+	for i := 0; i < 50; i++ {
+		m := NewMap[string, int]()
+		for j := 0; j < 10; j++ {
+			m.Set(float64((j*7)%10), fmt.Sprintf("k%d", j), j)
+		}
+		m.Set(10, "a", 10)
+		var removed []string
+		n := m.RemoveRangeByLex(lr, func(score float64, k string, v int) {
+			removed = append(removed, k)
+		})
+		if n != int64(len(removed)) || len(m.values) != int(m.Length()) || m.Length() != 11-n {
+			t.Fatal(n, removed, m.values)
+		}
+		for _, k := range removed {
+			if _, ok := m.GetScore(k); ok {
+				t.Fatal(k, "not removed")
+			}
+		}
+	}
+}
+
+func TestSortedMapBlockingPop(t *testing.T) {
+	m := NewMap[string, string]()
+	done := make(chan []Entry[string, float64, string], 1)
+	go func() {
+		entries, err := m.BlockingPopMin(context.Background(), 2)
+		if err != nil {
+			t.Error(err)
+		}
+		done <- entries
+	}()
+	waitBlocked(t, m.SortedSet, 1)
+	m.Set(1, "a", "value")
+	entries := <-done
+	if len(entries) != 1 || entries[0].Value != "value" {
+		t.Error(entries)
+	}
+	if len(m.values) != 0 {
+		t.Error(m.values)
+	}
+}
+
+func TestSortedMapMany(t *testing.T) {
+	m := NewMap[string, int]()
+	results, err := m.SetMany([]Entry[string, float64, int]{{"a", 1, 1}, {"b", 2, 2}, {"a", 3, 3}})
+	if err != nil || results[0] != Added || results[2] != Updated {
+		t.Error(results, err)
+	}
+	if v, _ := m.Get("a"); v != 3 {
+		t.Error(v)
+	}
+	if _, err := m.SetMany([]Entry[string, float64, int]{{"c", math.NaN(), 1}}); err != ErrNaN {
+		t.Error(err)
+	}
+	m.DeleteMany([]string{"a", "c"})
+	if _, ok := m.values["a"]; ok || len(m.values) != 1 {
+		t.Error(m.values)
+	}
+}
+
+func TestMapIterator(t *testing.T) {
+	m := NewMap[string, int]()
+	m.Set(1, "a", 1)
+	m.Set(2, "b", 2)
+	it := m.Iterator()
+	sum := 0
+	for it.Next() {
+		sum += it.Value()
+	}
+	if sum != 3 || it.Value() != 0 {
+		t.Error(sum, it.Value())
+	}
+	if !it.SeekKey("b") || it.Value() != 2 {
+		t.Error(it.Value())
+	}
+}
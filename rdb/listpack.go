@@ -0,0 +1,279 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"strconv"
+
+	"github.com/liyiheng/zset"
+)
+
+/*-----------------------------------------------------------------------------
+ * Listpacks (listpack.c) and ziplists (ziplist.c)
+ *
+ * Small sorted sets are stored by Redis as a listpack, or as a ziplist by
+ * versions older than 7.0, of alternating members and scores in ascending
+ * order. Both formats store strings which look like integers as integers.
+ *----------------------------------------------------------------------------*/
+
+const (
+	lpHeaderSize = 6
+	lpEOF        = 0xFF
+	lpMaxCount   = 65535 /* Count of elements unknown, listpack.c LP_HDR_NUMELE_UNKNOWN */
+
+	zlHeaderSize = 10
+	zlEnd        = 0xFF
+	zlBigPrevlen = 254
+	zlMaxCount   = 65535 /* Count of elements unknown */
+)
+
+/* Like string2ll(), only canonical decimal integers are stored as such:
+ * no sign for positive values, no leading zeros and no "-0". */
+func stringToInt(s string) (int64, bool) {
+	if len(s) == 0 || len(s) > 20 {
+		return 0, false
+	}
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || strconv.FormatInt(v, 10) != s {
+		return 0, false
+	}
+	return v, true
+}
+
+/* Appends a listpack of the elements to b, see lpAppend(). */
+func appendListpack(b []byte, elements []string) []byte {
+	start := len(b)
+	b = append(b, make([]byte, lpHeaderSize)...)
+	for _, s := range elements {
+		b = lpAppend(b, s)
+	}
+	b = append(b, lpEOF)
+
+	count := len(elements)
+	if count > lpMaxCount {
+		count = lpMaxCount
+	}
+	binary.LittleEndian.PutUint32(b[start:], uint32(len(b)-start))
+	binary.LittleEndian.PutUint16(b[start+4:], uint16(count))
+	return b
+}
+
+func lpAppend(b []byte, s string) []byte {
+	start := len(b)
+	if v, ok := stringToInt(s); ok {
+		switch {
+		case v >= 0 && v <= 127:
+			b = append(b, byte(v))
+		case v >= -4096 && v <= 4095:
+			u := uint64(v) & (1<<13 - 1)
+			b = append(b, 0xC0|byte(u>>8), byte(u))
+		case v >= -32768 && v <= 32767:
+			b = append(b, 0xF1, byte(v), byte(v>>8))
+		case v >= -8388608 && v <= 8388607:
+			b = append(b, 0xF2, byte(v), byte(v>>8), byte(v>>16))
+		case v >= -2147483648 && v <= 2147483647:
+			b = append(b, 0xF3, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+		default:
+			b = append(b, 0xF4)
+			b = appendUint64(b, uint64(v))
+		}
+	} else {
+		switch l := len(s); {
+		case l < 64:
+			b = append(b, 0x80|byte(l))
+		case l < 4096:
+			b = append(b, 0xE0|byte(l>>8), byte(l))
+		default:
+			b = append(b, 0xF0, byte(l), byte(l>>8), byte(l>>16), byte(l>>24))
+		}
+		b = append(b, s...)
+	}
+	return lpEncodeBacklen(b, uint64(len(b)-start))
+}
+
+/* The length of the entry, written so that it can be read backwards. */
+func lpEncodeBacklen(b []byte, l uint64) []byte {
+	switch {
+	case l <= 127:
+		return append(b, byte(l))
+	case l < 16383:
+		return append(b, byte(l>>7), byte(l&127)|128)
+	case l < 2097151:
+		return append(b, byte(l>>14), byte((l>>7)&127)|128, byte(l&127)|128)
+	case l < 268435455:
+		return append(b, byte(l>>21), byte((l>>14)&127)|128, byte((l>>7)&127)|128, byte(l&127)|128)
+	}
+	return append(b, byte(l>>28), byte((l>>21)&127)|128, byte((l>>14)&127)|128, byte((l>>7)&127)|128, byte(l&127)|128)
+}
+
+/* Returns the elements of a listpack, integers formatted in decimal. */
+func lpElements(lp []byte) ([]string, error) {
+	if len(lp) < lpHeaderSize+1 || binary.LittleEndian.Uint32(lp) != uint32(len(lp)) {
+		return nil, zset.ErrBadEncoding
+	}
+	count := int(binary.LittleEndian.Uint16(lp[4:]))
+	var elements []string
+	if count != lpMaxCount {
+		elements = make([]string, 0, count)
+	}
+	p := lp[lpHeaderSize:]
+	for len(p) > 0 && p[0] != lpEOF {
+		s, n, err := lpGet(p)
+		if err != nil {
+			return nil, err
+		}
+		/* The backlen is checked, as a cheap sanity check of the entry. */
+		var backlen [5]byte
+		bl := lpEncodeBacklen(backlen[:0], uint64(n))
+		if len(p) < n+len(bl) || string(p[n:n+len(bl)]) != string(bl) {
+			return nil, zset.ErrBadEncoding
+		}
+		elements = append(elements, s)
+		p = p[n+len(bl):]
+	}
+	if len(p) != 1 || (count != lpMaxCount && count != len(elements)) {
+		return nil, zset.ErrBadEncoding
+	}
+	return elements, nil
+}
+
+/* Decodes the entry at the start of p, and returns the size of its
+ * encoding and data. */
+func lpGet(p []byte) (s string, n int, err error) {
+	enc := p[0]
+	var header, l int
+	switch {
+	case enc&0x80 == 0: /* 7 bit unsigned integer */
+		return strconv.Itoa(int(enc)), 1, nil
+	case enc&0xC0 == 0x80: /* 6 bit length string */
+		header, l = 1, int(enc&0x3F)
+	case enc&0xE0 == 0xC0: /* 13 bit signed integer */
+		if len(p) < 2 {
+			return "", 0, zset.ErrBadEncoding
+		}
+		u := uint16(enc&0x1F)<<8 | uint16(p[1])
+		return strconv.Itoa(int(int16(u<<3) >> 3)), 2, nil
+	case enc&0xF0 == 0xE0: /* 12 bit length string */
+		if len(p) < 2 {
+			return "", 0, zset.ErrBadEncoding
+		}
+		header, l = 2, int(enc&0x0F)<<8|int(p[1])
+	case enc == 0xF0: /* 32 bit length string */
+		if len(p) < 5 {
+			return "", 0, zset.ErrBadEncoding
+		}
+		u := binary.LittleEndian.Uint32(p[1:])
+		if uint64(u) > uint64(len(p)-5) {
+			return "", 0, zset.ErrBadEncoding
+		}
+		header, l = 5, int(u)
+	case enc >= 0xF1 && enc <= 0xF4: /* 16, 24, 32 and 64 bit signed integers */
+		size := [...]int{2, 3, 4, 8}[enc-0xF1]
+		if len(p) < 1+size {
+			return "", 0, zset.ErrBadEncoding
+		}
+		return strconv.FormatInt(intLE(p[1:1+size]), 10), 1 + size, nil
+	default:
+		return "", 0, zset.ErrBadEncoding
+	}
+	if len(p) < header+l {
+		return "", 0, zset.ErrBadEncoding
+	}
+	return string(p[header : header+l]), header + l, nil
+}
+
+/* Sign extends a little endian integer of up to 8 bytes. */
+func intLE(b []byte) int64 {
+	var u uint64
+	for i := len(b) - 1; i >= 0; i-- {
+		u = u<<8 | uint64(b[i])
+	}
+	shift := 64 - 8*len(b)
+	return int64(u<<shift) >> shift
+}
+
+/* Returns the elements of a ziplist, integers formatted in decimal. */
+func zlElements(zl []byte) ([]string, error) {
+	if len(zl) < zlHeaderSize+1 || binary.LittleEndian.Uint32(zl) != uint32(len(zl)) || zl[len(zl)-1] != zlEnd {
+		return nil, zset.ErrBadEncoding
+	}
+	count := int(binary.LittleEndian.Uint16(zl[8:]))
+	var elements []string
+	p := zl[zlHeaderSize:]
+	for p[0] != zlEnd {
+		/* prevlen */
+		if p[0] < zlBigPrevlen {
+			p = p[1:]
+		} else if len(p) > 5 {
+			p = p[5:]
+		} else {
+			return nil, zset.ErrBadEncoding
+		}
+		s, n, err := zlGet(p)
+		if err != nil {
+			return nil, err
+		}
+		elements = append(elements, s)
+		p = p[n:]
+		if len(p) == 0 {
+			return nil, zset.ErrBadEncoding
+		}
+	}
+	if len(p) != 1 || (count != zlMaxCount && count != len(elements)) {
+		return nil, zset.ErrBadEncoding
+	}
+	return elements, nil
+}
+
+/* Decodes the entry after the prevlen at the start of p, and returns the
+ * size of its encoding and data. */
+func zlGet(p []byte) (s string, n int, err error) {
+	if len(p) == 0 {
+		return "", 0, zset.ErrBadEncoding
+	}
+	enc := p[0]
+	var header, l int
+	switch enc >> 6 {
+	case 0: /* 6 bit length string */
+		header, l = 1, int(enc&0x3F)
+	case 1: /* 14 bit length string, big endian */
+		if len(p) < 2 {
+			return "", 0, zset.ErrBadEncoding
+		}
+		header, l = 2, int(enc&0x3F)<<8|int(p[1])
+	case 2: /* 32 bit length string, big endian */
+		if enc != 0x80 || len(p) < 5 {
+			return "", 0, zset.ErrBadEncoding
+		}
+		u := binary.BigEndian.Uint32(p[1:])
+		if uint64(u) > uint64(len(p)-5) {
+			return "", 0, zset.ErrBadEncoding
+		}
+		header, l = 5, int(u)
+	default: /* integers, little endian */
+		var size int
+		switch {
+		case enc == 0xC0:
+			size = 2
+		case enc == 0xD0:
+			size = 4
+		case enc == 0xE0:
+			size = 8
+		case enc == 0xF0:
+			size = 3
+		case enc == 0xFE:
+			size = 1
+		case enc >= 0xF1 && enc <= 0xFD: /* 4 bit immediate, 0 to 12 */
+			return strconv.Itoa(int(enc&0x0F) - 1), 1, nil
+		default:
+			return "", 0, zset.ErrBadEncoding
+		}
+		if len(p) < 1+size {
+			return "", 0, zset.ErrBadEncoding
+		}
+		return strconv.FormatInt(intLE(p[1:1+size]), 10), 1 + size, nil
+	}
+	if len(p) < header+l {
+		return "", 0, zset.ErrBadEncoding
+	}
+	return string(p[header : header+l]), header + l, nil
+}
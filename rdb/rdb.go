@@ -0,0 +1,520 @@
+// Package rdb reads and writes sorted sets in the RDB format of Redis, to
+// move them between Redis and SortedSets: as values, as DUMP payloads for
+// the RESTORE command, and from RDB files like dump.rdb.
+package rdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc64"
+	"io"
+	"math"
+	"strconv"
+
+	"github.com/liyiheng/zset"
+)
+
+// Types of RDB values holding sorted sets.
+const (
+	TypeZSet         = 3  // Scores as strings, before Redis 3.2
+	TypeZSet2        = 5  // Scores as binary doubles
+	TypeZSetZiplist  = 12 // Small sets, before Redis 7.0
+	TypeZSetListpack = 17 // Small sets, since Redis 7.0
+)
+
+// Sets with at most ListpackMaxEntries elements, whose members are at most
+// ListpackMaxValue bytes long, are encoded as listpacks, like Redis does
+// with its default zset-max-listpack-entries and zset-max-listpack-value.
+const (
+	ListpackMaxEntries = 128
+	ListpackMaxValue   = 64
+)
+
+/* The version of DUMP payloads, the one of Redis 7.0 which introduced
+ * listpacks, so that any later version accepts them. */
+const dumpVersion = 10
+
+/* The newest RDB version whose sorted sets can be decoded, the one of
+ * Redis 7.4. */
+const maxVersion = 12
+
+// ErrNotZSet is returned when decoding a value which is not a sorted set.
+var ErrNotZSet = errors.New("rdb: value is not a sorted set")
+
+// ErrUnsupported is returned when reading a value type or an opcode which
+// is not known to this package, e.g. from a newer version of Redis.
+var ErrUnsupported = errors.New("rdb: unsupported value type or opcode")
+
+/* Lengths, see rdbLoadLen(). */
+const (
+	rdb6BitLen  = 0
+	rdb14BitLen = 1
+	rdb32BitLen = 0x80
+	rdb64BitLen = 0x81
+	rdbEncVal   = 3
+
+	rdbEncInt8  = 0
+	rdbEncInt16 = 1
+	rdbEncInt32 = 2
+	rdbEncLZF   = 3
+)
+
+var crcTable = crc64.MakeTable(0x95AC9329AC4BC9B5)
+
+/* crc64() of Redis, the Jones polynomial without the inversions of
+ * hash/crc64. */
+func crc64Update(crc uint64, p []byte) uint64 {
+	return ^crc64.Update(^crc, crcTable, p)
+}
+
+// AppendValue appends z to b as an RDB value, its type byte first, like in
+// DUMP payloads and RDB files. Small sets are encoded as a listpack, other
+// ones as TypeZSet2.
+func AppendValue(b []byte, z *zset.SortedSet[string, float64]) []byte {
+	var members []zset.Member[string, float64]
+	small := true
+	z.Range(0, -1, func(score float64, key string) {
+		members = append(members, zset.Member[string, float64]{Key: key, Score: score})
+		small = small && len(key) <= ListpackMaxValue
+	})
+
+	if small && len(members) <= ListpackMaxEntries {
+		elements := make([]string, 0, 2*len(members))
+		for _, m := range members {
+			elements = append(elements, m.Key, formatScore(m.Score))
+		}
+		b = append(b, TypeZSetListpack)
+		return appendString(b, string(appendListpack(nil, elements)))
+	}
+
+	/* Like rdbSaveObject(), from the tail, so that Redis inserts every
+	 * element at the head of its skiplist when loading it. */
+	b = append(b, TypeZSet2)
+	b = appendLen(b, uint64(len(members)))
+	for i := len(members) - 1; i >= 0; i-- {
+		b = appendString(b, members[i].Key)
+		b = appendUint64(b, math.Float64bits(members[i].Score))
+	}
+	return b
+}
+
+// DecodeValue decodes an RDB value of one of the sorted set types, starting
+// with its type byte. It returns ErrNotZSet for other types.
+func DecodeValue(data []byte) (*zset.SortedSet[string, float64], error) {
+	if len(data) == 0 {
+		return nil, zset.ErrBadEncoding
+	}
+	r := bytes.NewReader(data[1:])
+	d := &decoder{r: r}
+	z, err := d.readZSet(data[0])
+	if err == io.ErrUnexpectedEOF {
+		return nil, zset.ErrBadEncoding
+	} else if err != nil {
+		return nil, err
+	}
+	if r.Len() != 0 {
+		return nil, zset.ErrBadEncoding
+	}
+	return z, nil
+}
+
+// Dump returns z serialized like the DUMP command does, to be loaded into
+// Redis 7.0 or later with RESTORE.
+func Dump(z *zset.SortedSet[string, float64]) []byte {
+	b := AppendValue(nil, z)
+	b = append(b, dumpVersion&0xFF, dumpVersion>>8)
+	return appendUint64(b, crc64Update(0, b))
+}
+
+// Restore decodes a payload returned by the DUMP command of Redis for a
+// sorted set. It returns zset.ErrChecksum if its checksum doesn't match,
+// and ErrUnsupported if it comes from a newer RDB version than known,
+// like verifyDumpPayload() does.
+func Restore(payload []byte) (*zset.SortedSet[string, float64], error) {
+	if len(payload) < 10 {
+		return nil, zset.ErrBadEncoding
+	}
+	body, sum := payload[:len(payload)-8], payload[len(payload)-8:]
+	if version := binary.LittleEndian.Uint16(body[len(body)-2:]); version > maxVersion {
+		return nil, ErrUnsupported
+	}
+	if crc64Update(0, body) != binary.LittleEndian.Uint64(sum) {
+		return nil, zset.ErrChecksum
+	}
+	return DecodeValue(body[:len(body)-2])
+}
+
+/*-----------------------------------------------------------------------------
+ * Encoding
+ *----------------------------------------------------------------------------*/
+
+/* Like d2string(), integers are formatted as such, so that the listpack
+ * stores them as integers. */
+func formatScore(score float64) string {
+	switch {
+	case math.IsInf(score, 1):
+		return "inf"
+	case math.IsInf(score, -1):
+		return "-inf"
+	case score == 0 && math.Signbit(score):
+		return "-0"
+	case score == math.Trunc(score) && math.Abs(score) < 1<<52:
+		return strconv.FormatInt(int64(score), 10)
+	}
+	return strconv.FormatFloat(score, 'g', -1, 64)
+}
+
+func appendLen(b []byte, n uint64) []byte {
+	switch {
+	case n < 1<<6:
+		return append(b, byte(n))
+	case n < 1<<14:
+		return append(b, rdb14BitLen<<6|byte(n>>8), byte(n))
+	case n <= math.MaxUint32:
+		return append(b, rdb32BitLen, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+	b = append(b, rdb64BitLen)
+	for shift := 56; shift >= 0; shift -= 8 {
+		b = append(b, byte(n>>shift))
+	}
+	return b
+}
+
+func appendString(b []byte, s string) []byte {
+	return append(appendLen(b, uint64(len(s))), s...)
+}
+
+func appendUint64(b []byte, x uint64) []byte {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], x)
+	return append(b, buf[:]...)
+}
+
+/*-----------------------------------------------------------------------------
+ * Decoding
+ *----------------------------------------------------------------------------*/
+
+/* Reads values, keeping the checksum of everything read. */
+type decoder struct {
+	r   io.Reader
+	crc uint64
+	buf [8]byte
+}
+
+func (d *decoder) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	d.crc = crc64Update(d.crc, p[:n])
+	return n, err
+}
+
+/* Reads exactly n bytes, or fails with io.ErrUnexpectedEOF. Big lengths,
+ * which may be corrupted, don't allocate more than what is read. */
+func (d *decoder) read(n uint64) ([]byte, error) {
+	if n > math.MaxInt64 {
+		return nil, zset.ErrBadEncoding
+	} else if n <= 1<<16 {
+		b := make([]byte, n)
+		if _, err := io.ReadFull(d, b); err != nil {
+			return nil, unexpected(err)
+		}
+		return b, nil
+	}
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, d, int64(n)); err != nil {
+		return nil, unexpected(err)
+	}
+	return buf.Bytes(), nil
+}
+
+func (d *decoder) skip(n uint64) error {
+	if n > math.MaxInt64 {
+		return zset.ErrBadEncoding
+	}
+	_, err := io.CopyN(io.Discard, d, int64(n))
+	return unexpected(err)
+}
+
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func (d *decoder) readByte() (byte, error) {
+	if _, err := io.ReadFull(d, d.buf[:1]); err != nil {
+		return 0, unexpected(err)
+	}
+	return d.buf[0], nil
+}
+
+func (d *decoder) readUint64() (uint64, error) {
+	if _, err := io.ReadFull(d, d.buf[:8]); err != nil {
+		return 0, unexpected(err)
+	}
+	return binary.LittleEndian.Uint64(d.buf[:]), nil
+}
+
+/* Reads a length, or the type of a specially encoded string if encoded,
+ * see rdbLoadLenByRef(). */
+func (d *decoder) readLen() (n uint64, encoded bool, err error) {
+	c, err := d.readByte()
+	if err != nil {
+		return 0, false, err
+	}
+	switch c >> 6 {
+	case rdb6BitLen:
+		return uint64(c & 0x3F), false, nil
+	case rdb14BitLen:
+		next, err := d.readByte()
+		return uint64(c&0x3F)<<8 | uint64(next), false, err
+	case rdbEncVal:
+		return uint64(c & 0x3F), true, nil
+	}
+	switch c {
+	case rdb32BitLen:
+		if _, err := io.ReadFull(d, d.buf[:4]); err != nil {
+			return 0, false, unexpected(err)
+		}
+		return uint64(binary.BigEndian.Uint32(d.buf[:])), false, nil
+	case rdb64BitLen:
+		if _, err := io.ReadFull(d, d.buf[:8]); err != nil {
+			return 0, false, unexpected(err)
+		}
+		return binary.BigEndian.Uint64(d.buf[:]), false, nil
+	}
+	return 0, false, zset.ErrBadEncoding
+}
+
+/* Reads a length which is not a string. */
+func (d *decoder) readCount() (uint64, error) {
+	n, encoded, err := d.readLen()
+	if err == nil && encoded {
+		err = zset.ErrBadEncoding
+	}
+	return n, err
+}
+
+/* Reads a string, integer encoded or compressed ones included, see
+ * rdbGenericLoadStringObject(). */
+func (d *decoder) readString() (string, error) {
+	n, encoded, err := d.readLen()
+	if err != nil {
+		return "", err
+	}
+	if !encoded {
+		b, err := d.read(n)
+		return string(b), err
+	}
+	switch n {
+	case rdbEncInt8, rdbEncInt16, rdbEncInt32:
+		size := 1 << n
+		if _, err := io.ReadFull(d, d.buf[:size]); err != nil {
+			return "", unexpected(err)
+		}
+		return strconv.FormatInt(intLE(d.buf[:size]), 10), nil
+	case rdbEncLZF:
+		clen, err := d.readCount()
+		if err != nil {
+			return "", err
+		}
+		ulen, err := d.readCount()
+		if err != nil {
+			return "", err
+		}
+		in, err := d.read(clen)
+		if err != nil {
+			return "", err
+		}
+		out, err := lzfDecompress(in, ulen)
+		return string(out), err
+	}
+	return "", zset.ErrBadEncoding
+}
+
+/* Skips a string without decoding it. */
+func (d *decoder) skipString() error {
+	n, encoded, err := d.readLen()
+	if err != nil {
+		return err
+	}
+	if !encoded {
+		return d.skip(n)
+	}
+	switch n {
+	case rdbEncInt8, rdbEncInt16, rdbEncInt32:
+		return d.skip(1 << n)
+	case rdbEncLZF:
+		clen, err := d.readCount()
+		if err != nil {
+			return err
+		}
+		if _, err := d.readCount(); err != nil {
+			return err
+		}
+		return d.skip(clen)
+	}
+	return zset.ErrBadEncoding
+}
+
+/* Reads a score of TypeZSet, see rdbLoadDoubleValue(). */
+func (d *decoder) readDoubleString() (float64, error) {
+	l, err := d.readByte()
+	if err != nil {
+		return 0, err
+	}
+	switch l {
+	case 253:
+		return math.NaN(), nil
+	case 254:
+		return math.Inf(1), nil
+	case 255:
+		return math.Inf(-1), nil
+	}
+	b, err := d.read(uint64(l))
+	if err != nil {
+		return 0, err
+	}
+	return parseScore(string(b))
+}
+
+func parseScore(s string) (float64, error) {
+	score, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, zset.ErrBadEncoding
+	}
+	return score, nil
+}
+
+/* Reads a value of one of the sorted set types, whose type byte was read. */
+func (d *decoder) readZSet(typ byte) (*zset.SortedSet[string, float64], error) {
+	var members []zset.Member[string, float64]
+	add := func(score float64, member string) error {
+		if math.IsNaN(score) {
+			return zset.ErrBadEncoding
+		}
+		members = append(members, zset.Member[string, float64]{Key: member, Score: score})
+		return nil
+	}
+
+	switch typ {
+	case TypeZSet, TypeZSet2:
+		n, err := d.readCount()
+		if err != nil {
+			return nil, err
+		}
+		for i := uint64(0); i < n; i++ {
+			member, err := d.readString()
+			if err != nil {
+				return nil, err
+			}
+			var score float64
+			if typ == TypeZSet {
+				score, err = d.readDoubleString()
+			} else {
+				var bits uint64
+				bits, err = d.readUint64()
+				score = math.Float64frombits(bits)
+			}
+			if err != nil {
+				return nil, err
+			}
+			if err := add(score, member); err != nil {
+				return nil, err
+			}
+		}
+		/* Saved from the tail, reversed they can be built without sorting. */
+		for i, j := 0, len(members)-1; i < j; i, j = i+1, j-1 {
+			members[i], members[j] = members[j], members[i]
+		}
+	case TypeZSetZiplist, TypeZSetListpack:
+		s, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		var elements []string
+		if typ == TypeZSetZiplist {
+			elements, err = zlElements([]byte(s))
+		} else {
+			elements, err = lpElements([]byte(s))
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(elements)%2 != 0 {
+			return nil, zset.ErrBadEncoding
+		}
+		members = make([]zset.Member[string, float64], 0, len(elements)/2)
+		for i := 0; i < len(elements); i += 2 {
+			score, err := parseScore(elements[i+1])
+			if err != nil {
+				return nil, err
+			}
+			if err := add(score, elements[i]); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		return nil, ErrNotZSet
+	}
+
+	z := zset.New[string]()
+	b := zset.NewBuilder(z)
+	b.Grow(len(members))
+	for _, m := range members {
+		b.Add(m.Score, m.Key)
+	}
+	if err := b.Build(); err != nil {
+		return nil, err
+	}
+	return z, nil
+}
+
+/* Decompresses LZF data into ulen bytes, see lzf_d.c. */
+func lzfDecompress(in []byte, ulen uint64) ([]byte, error) {
+	/* A back reference of 3 bytes expands to at most 264 bytes. */
+	if ulen > uint64(len(in))*88 {
+		return nil, zset.ErrBadEncoding
+	}
+	out := make([]byte, 0, ulen)
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+		if ctrl < 1<<5 { /* literal run */
+			ctrl++
+			if i+ctrl > len(in) || uint64(len(out)+ctrl) > ulen {
+				return nil, zset.ErrBadEncoding
+			}
+			out = append(out, in[i:i+ctrl]...)
+			i += ctrl
+			continue
+		}
+		/* back reference */
+		l := ctrl >> 5
+		if l == 7 {
+			if i >= len(in) {
+				return nil, zset.ErrBadEncoding
+			}
+			l += int(in[i])
+			i++
+		}
+		if i >= len(in) {
+			return nil, zset.ErrBadEncoding
+		}
+		ref := len(out) - (ctrl&0x1F)<<8 - int(in[i]) - 1
+		i++
+		l += 2
+		if ref < 0 || uint64(len(out)+l) > ulen {
+			return nil, zset.ErrBadEncoding
+		}
+		for ; l > 0; l-- {
+			out = append(out, out[ref])
+			ref++
+		}
+	}
+	if uint64(len(out)) != ulen {
+		return nil, zset.ErrBadEncoding
+	}
+	return out, nil
+}
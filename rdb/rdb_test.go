@@ -0,0 +1,185 @@
+package rdb
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/liyiheng/zset"
+)
+
+func TestCRC64(t *testing.T) {
+	/* The test vector of crc64.c */
+	if crc := crc64Update(0, []byte("123456789")); crc != 0xe9c6d914c4b8d9ca {
+		t.Errorf("%x", crc)
+	}
+}
+
+func equal(t *testing.T, a, b *zset.SortedSet[string, float64]) {
+	t.Helper()
+	if a.Length() != b.Length() {
+		t.Fatal(a.Length(), b.Length())
+	}
+	a.Range(0, -1, func(score float64, key string) {
+		got, ok := b.GetScore(key)
+		if !ok || got != score || math.Signbit(got) != math.Signbit(score) {
+			t.Error(key, score, got, ok)
+		}
+	})
+}
+
+func TestValue(t *testing.T) {
+	z := zset.New[string]()
+	z.Set(1, "a")
+	z.Set(2.5, "b")
+	z.Set(-5000, "123")
+	z.Set(math.Copysign(0, -1), "-0")
+	z.Set(1<<40, "007")
+	z.Set(-1<<62, "-1")
+	z.Set(1e300, "big")
+	z.Set(math.Inf(1), "+inf")
+	z.Set(math.Inf(-1), "-inf")
+	z.Set(0.1, strings.Repeat("x", ListpackMaxValue))
+	data := AppendValue(nil, z)
+	if data[0] != TypeZSetListpack {
+		t.Fatal(data[0])
+	}
+	y, err := DecodeValue(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	equal(t, z, y)
+
+	/* Too long a member */
+	z.Set(3, strings.Repeat("x", ListpackMaxValue+1))
+	data = AppendValue(nil, z)
+	if data[0] != TypeZSet2 {
+		t.Fatal(data[0])
+	}
+	if y, err = DecodeValue(data); err != nil {
+		t.Fatal(err)
+	}
+	equal(t, z, y)
+
+	/* Too many elements */
+	z = zset.New[string]()
+	for i := 0; i < 1000; i++ {
+		z.Set(float64(i%7), "k"+strconv.Itoa(i))
+	}
+	data = AppendValue(nil, z)
+	if data[0] != TypeZSet2 {
+		t.Fatal(data[0])
+	}
+	if y, err = DecodeValue(data); err != nil {
+		t.Fatal(err)
+	}
+	equal(t, z, y)
+}
+
+func TestListpack(t *testing.T) {
+	z := zset.New[string]()
+	z.Set(1, "a")
+	want := "\x11\x0c\x0c\x00\x00\x00\x02\x00\x81a\x02\x01\x01\xff"
+	if got := string(AppendValue(nil, z)); got != want {
+		t.Errorf("%q", got)
+	}
+
+	for _, s := range []string{
+		"0", "127", "128", "-1", "4095", "-4096", "4096", "32767", "-32768",
+		"8388607", "-8388608", "2147483647", "-2147483648", "9223372036854775807",
+		"-9223372036854775808", "01", "+1", "-0", "", "x",
+		strings.Repeat("y", 63), strings.Repeat("y", 64), strings.Repeat("y", 4095), strings.Repeat("y", 4096),
+	} {
+		lp := appendListpack(nil, []string{s, "z"})
+		elements, err := lpElements(lp)
+		if err != nil || len(elements) != 2 || elements[0] != s || elements[1] != "z" {
+			t.Errorf("%.10q %q %v", s, elements, err)
+		}
+	}
+}
+
+func TestDecodeValue(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		data string
+		want map[string]float64
+	}{
+		{"zset", "\x03\x02\x01a\x031.5\x01b\xfe", map[string]float64{"a": 1.5, "b": math.Inf(1)}},
+		{"ziplist", "\x0c\x18" +
+			"\x18\x00\x00\x00\x12\x00\x00\x00\x04\x00" +
+			"\x00\x01a\x03\xf2\x02\x01b\x03\x032.5\xff",
+			map[string]float64{"a": 1, "b": 2.5}},
+		{"encoded strings", "\x05\x02" +
+			"\xc3\x05\x0a\x00a\xe0\x00\x00" + "\x00\x00\x00\x00\x00\x00\xf0\x3f" +
+			"\xc0\x7b" + "\x00\x00\x00\x00\x00\x00\x00\x40",
+			map[string]float64{"aaaaaaaaaa": 1, "123": 2}},
+	} {
+		z, err := DecodeValue([]byte(tc.data))
+		if err != nil {
+			t.Error(tc.name, err)
+			continue
+		}
+		if z.Length() != int64(len(tc.want)) {
+			t.Error(tc.name, z.Length())
+		}
+		for key, want := range tc.want {
+			if score, ok := z.GetScore(key); !ok || score != want {
+				t.Error(tc.name, key, score, ok)
+			}
+		}
+	}
+}
+
+func TestDecodeValueErrors(t *testing.T) {
+	for _, tc := range []struct {
+		data string
+		err  error
+	}{
+		{"", zset.ErrBadEncoding},
+		{"\x00\x01a", ErrNotZSet},
+		{"\x03\x02\x01a\x011\x01a\x012", zset.ErrDuplicateKey},
+		{"\x03\x01\x01a\xfd", zset.ErrBadEncoding},
+		{"\x03\x01\x01a\x01x", zset.ErrBadEncoding},
+		{"\x03\x02\x01a\x011", zset.ErrBadEncoding},
+		{"\x03\x01\x01a\x011\x00", zset.ErrBadEncoding},
+		{"\x05\x01\x01a\x00\x00\x00", zset.ErrBadEncoding},
+		{"\x05\x01\xc3\x05\x0b\x00a\xe0\x00\x00\x00\x00\x00\x00\x00\x00\xf0\x3f", zset.ErrBadEncoding},
+		{"\x11\x08\x08\x00\x00\x00\x01\x00\x01\x01", zset.ErrBadEncoding},
+		{"\x11\x0a\x0a\x00\x00\x00\x01\x00\x01\x01\xff\xff", zset.ErrBadEncoding},
+		{"\x11\x07\x08\x00\x00\x00\x00\x00\xff", zset.ErrBadEncoding},
+		{"\x11\x09\x09\x00\x00\x00\x01\x00\x01\x01\xff", zset.ErrBadEncoding},
+	} {
+		if _, err := DecodeValue([]byte(tc.data)); err != tc.err {
+			t.Errorf("%q %v", tc.data, err)
+		}
+	}
+}
+
+func TestDump(t *testing.T) {
+	z := zset.New[string]()
+	z.Set(1, "a")
+	z.Set(2, "b")
+	payload := Dump(z)
+	y, err := Restore(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	equal(t, z, y)
+
+	/* A newer RDB version, with a valid checksum. */
+	newer := append([]byte(nil), payload[:len(payload)-10]...)
+	newer = append(newer, maxVersion+1, 0)
+	newer = appendUint64(newer, crc64Update(0, newer))
+	if _, err := Restore(newer); err != ErrUnsupported {
+		t.Error(err)
+	}
+
+	payload[0] ^= 1
+	if _, err := Restore(payload); err != zset.ErrChecksum {
+		t.Error(err)
+	}
+	if _, err := Restore(payload[:5]); err != zset.ErrBadEncoding {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,429 @@
+package rdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/liyiheng/zset"
+)
+
+/* Opcodes of RDB files, see rdb.h. */
+const (
+	opSlotInfo     = 0xF4
+	opFunction2    = 0xF5
+	opFunctionPre  = 0xF6
+	opModuleAux    = 0xF7
+	opIdle         = 0xF8
+	opFreq         = 0xF9
+	opAux          = 0xFA
+	opResizeDB     = 0xFB
+	opExpireTimeMS = 0xFC
+	opExpireTime   = 0xFD
+	opSelectDB     = 0xFE
+	opEOF          = 0xFF
+)
+
+/* Types of other values, which are skipped. */
+const (
+	typeString            = 0
+	typeList              = 1
+	typeSet               = 2
+	typeHash              = 4
+	typeModule2           = 7
+	typeHashZipmap        = 9
+	typeListZiplist       = 10
+	typeSetIntset         = 11
+	typeHashZiplist       = 13
+	typeListQuicklist     = 14
+	typeStreamListpacks   = 15
+	typeHashListpack      = 16
+	typeListQuicklist2    = 18
+	typeStreamListpacks2  = 19
+	typeSetListpack       = 20
+	typeStreamListpacks3  = 21
+	typeHashMetadataPre   = 22
+	typeHashListpackExPre = 23
+	typeHashMetadata      = 24
+	typeHashListpackEx    = 25
+)
+
+/* Opcodes of values serialized by modules, see module.c. */
+const (
+	moduleOpEOF    = 0
+	moduleOpSInt   = 1
+	moduleOpUInt   = 2
+	moduleOpFloat  = 3
+	moduleOpDouble = 4
+	moduleOpString = 5
+)
+
+// ErrBadHeader is returned when a file doesn't start like RDB files do.
+var ErrBadHeader = errors.New("rdb: not an RDB file")
+
+// Entry is a sorted set read from an RDB file.
+type Entry struct {
+	DB     int
+	Key    string
+	Expire time.Time // The zero Time if the key doesn't expire
+	Set    *zset.SortedSet[string, float64]
+}
+
+// Reader reads the sorted sets of an RDB file, skipping the other keys.
+type Reader struct {
+	d       decoder
+	version int
+	db      int
+	done    bool
+}
+
+// NewReader returns a Reader reading an RDB file from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{d: decoder{r: bufio.NewReader(r)}, version: -1}
+}
+
+// ReadFile reads all the sorted sets of the RDB file name.
+func ReadFile(name string) ([]Entry, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := NewReader(f)
+	var entries []Entry
+	for {
+		e, err := r.Next()
+		if err == io.EOF {
+			return entries, nil
+		} else if err != nil {
+			return entries, err
+		}
+		entries = append(entries, *e)
+	}
+}
+
+// Next returns the next sorted set of the file, or io.EOF once the end of
+// the file is read and its checksum verified. A truncated file makes it
+// fail with io.ErrUnexpectedEOF, a checksum mismatch with zset.ErrChecksum.
+func (r *Reader) Next() (*Entry, error) {
+	if r.done {
+		return nil, io.EOF
+	}
+	if r.version < 0 {
+		if err := r.readHeader(); err != nil {
+			return nil, err
+		}
+	}
+	d := &r.d
+	var expire time.Time
+	for {
+		typ, err := d.readByte()
+		if err != nil {
+			return nil, err
+		}
+		switch typ {
+		case opEOF:
+			return nil, r.readEOF()
+		case opSelectDB:
+			db, err := d.readCount()
+			if err != nil {
+				return nil, err
+			}
+			r.db = int(db)
+			continue
+		case opExpireTime:
+			if _, err := io.ReadFull(d, d.buf[:4]); err != nil {
+				return nil, unexpected(err)
+			}
+			expire = time.Unix(int64(int32(binary.LittleEndian.Uint32(d.buf[:]))), 0)
+			continue
+		case opExpireTimeMS:
+			ms, err := d.readUint64()
+			if err != nil {
+				return nil, err
+			}
+			expire = time.UnixMilli(int64(ms))
+			continue
+		case opResizeDB:
+			err = d.skipCounts(2)
+		case opSlotInfo:
+			err = d.skipCounts(3)
+		case opAux:
+			if err = d.skipString(); err == nil {
+				err = d.skipString()
+			}
+		case opFunction2:
+			err = d.skipString()
+		case opFunctionPre:
+			/* Like Redis, which stopped loading them in 7.0. */
+			return nil, ErrUnsupported
+		case opModuleAux:
+			err = d.skipModule()
+		case opFreq:
+			_, err = d.readByte()
+		case opIdle:
+			_, err = d.readCount()
+		default:
+			if typ > typeHashListpackEx {
+				return nil, ErrUnsupported
+			}
+			var key string
+			if key, err = d.readString(); err != nil {
+				return nil, err
+			}
+			switch typ {
+			case TypeZSet, TypeZSet2, TypeZSetZiplist, TypeZSetListpack:
+				z, err := d.readZSet(typ)
+				if err != nil {
+					return nil, err
+				}
+				return &Entry{DB: r.db, Key: key, Expire: expire, Set: z}, nil
+			}
+			/* The expire time was the one of this key. */
+			expire = time.Time{}
+			err = d.skipValue(typ)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (r *Reader) readHeader() error {
+	var header [9]byte
+	if _, err := io.ReadFull(&r.d, header[:]); err != nil {
+		return unexpected(err)
+	}
+	if string(header[:5]) != "REDIS" {
+		return ErrBadHeader
+	}
+	version, err := strconv.Atoi(string(header[5:]))
+	if err != nil || version < 1 {
+		return ErrBadHeader
+	}
+	r.version = version
+	return nil
+}
+
+/* Since version 5, the file ends with its checksum, 0 if disabled. */
+func (r *Reader) readEOF() error {
+	r.done = true
+	if r.version < 5 {
+		return io.EOF
+	}
+	crc := r.d.crc
+	sum, err := r.d.readUint64()
+	if err != nil {
+		return err
+	}
+	if sum != 0 && sum != crc {
+		return zset.ErrChecksum
+	}
+	return io.EOF
+}
+
+func (d *decoder) skipCounts(n int) error {
+	for i := 0; i < n; i++ {
+		if _, err := d.readCount(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *decoder) skipStrings(n uint64) error {
+	for i := uint64(0); i < n; i++ {
+		if err := d.skipString(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/* Skips a value of another type than sorted sets, see rdbLoadObject(). */
+func (d *decoder) skipValue(typ byte) error {
+	switch typ {
+	case typeString, typeHashZipmap, typeListZiplist, typeSetIntset,
+		typeHashZiplist, typeHashListpack, typeSetListpack:
+		return d.skipString()
+	case typeList, typeSet, typeListQuicklist, typeHash:
+		n, err := d.readCount()
+		if err != nil {
+			return err
+		}
+		if typ == typeHash {
+			n *= 2
+		}
+		return d.skipStrings(n)
+	case typeListQuicklist2:
+		n, err := d.readCount()
+		if err != nil {
+			return err
+		}
+		for i := uint64(0); i < n; i++ {
+			/* container, then the node */
+			if _, err := d.readCount(); err != nil {
+				return err
+			}
+			if err := d.skipString(); err != nil {
+				return err
+			}
+		}
+		return nil
+	case typeModule2:
+		return d.skipModule()
+	case typeStreamListpacks, typeStreamListpacks2, typeStreamListpacks3:
+		return d.skipStream(typ)
+	case typeHashListpackExPre, typeHashListpackEx:
+		/* min expire time, then the listpack */
+		if typ == typeHashListpackEx {
+			if err := d.skip(8); err != nil {
+				return err
+			}
+		}
+		return d.skipString()
+	case typeHashMetadataPre, typeHashMetadata:
+		return d.skipHashMetadata(typ)
+	}
+	return ErrUnsupported
+}
+
+/* Skips a hash with field expire times, see the RDB_TYPE_HASH_METADATA
+ * case of rdbLoadObject(). */
+func (d *decoder) skipHashMetadata(typ byte) error {
+	/* min expire time */
+	if typ == typeHashMetadata {
+		if err := d.skip(8); err != nil {
+			return err
+		}
+	}
+	n, err := d.readCount()
+	if err != nil {
+		return err
+	}
+	/* expire time, field and value */
+	for i := uint64(0); i < n; i++ {
+		if _, err := d.readCount(); err != nil {
+			return err
+		}
+		if err := d.skipStrings(2); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/* Skips the module id and the values it serialized with their opcodes. */
+func (d *decoder) skipModule() error {
+	if _, err := d.readCount(); err != nil {
+		return err
+	}
+	for {
+		op, err := d.readCount()
+		if err != nil {
+			return err
+		}
+		switch op {
+		case moduleOpEOF:
+			return nil
+		case moduleOpSInt, moduleOpUInt:
+			_, err = d.readCount()
+		case moduleOpFloat:
+			err = d.skip(4)
+		case moduleOpDouble:
+			err = d.skip(8)
+		case moduleOpString:
+			err = d.skipString()
+		default:
+			return zset.ErrBadEncoding
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+/* Skips a stream, see the RDB_TYPE_STREAM_LISTPACKS case of
+ * rdbLoadObject(). */
+func (d *decoder) skipStream(typ byte) error {
+	n, err := d.readCount()
+	if err != nil {
+		return err
+	}
+	/* node keys and listpacks */
+	if err := d.skipStrings(2 * n); err != nil {
+		return err
+	}
+	/* length and last id */
+	counts := 3
+	if typ >= typeStreamListpacks2 {
+		/* first id, max deleted id and entries added */
+		counts += 5
+	}
+	if err := d.skipCounts(counts); err != nil {
+		return err
+	}
+
+	groups, err := d.readCount()
+	if err != nil {
+		return err
+	}
+	for i := uint64(0); i < groups; i++ {
+		if err := d.skipString(); err != nil {
+			return err
+		}
+		/* last id, and entries read */
+		counts := 2
+		if typ >= typeStreamListpacks2 {
+			counts++
+		}
+		if err := d.skipCounts(counts); err != nil {
+			return err
+		}
+		/* pending entries: raw id, delivery time and count */
+		pending, err := d.readCount()
+		if err != nil {
+			return err
+		}
+		for j := uint64(0); j < pending; j++ {
+			if err := d.skip(16 + 8); err != nil {
+				return err
+			}
+			if _, err := d.readCount(); err != nil {
+				return err
+			}
+		}
+		/* consumers: name, seen time, active time and pending ids */
+		consumers, err := d.readCount()
+		if err != nil {
+			return err
+		}
+		for j := uint64(0); j < consumers; j++ {
+			if err := d.skipString(); err != nil {
+				return err
+			}
+			times := uint64(8)
+			if typ >= typeStreamListpacks3 {
+				times += 8
+			}
+			if err := d.skip(times); err != nil {
+				return err
+			}
+			pending, err := d.readCount()
+			if err != nil {
+				return err
+			}
+			if pending > (1<<63-1)/16 {
+				return zset.ErrBadEncoding
+			}
+			if err := d.skip(16 * pending); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package rdb
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/liyiheng/zset"
+)
+
+/* A file of Redis 7.4 with two sorted sets among other keys. */
+func testFile(checksum bool) []byte {
+	z := zset.New[string]()
+	z.Set(1, "a")
+	z.Set(2, "b")
+
+	b := []byte("REDIS0012")
+	b = append(b, opAux)
+	b = appendString(b, "redis-ver")
+	b = appendString(b, "7.4.0")
+	b = append(b, opAux, 0xC0, 7)
+	b = appendString(b, "x")
+	b = append(b, opFunction2)
+	b = appendString(b, "#!lua name=lib\nredis.register_function('f', function() end)")
+	b = append(b, opSelectDB, 0)
+	b = append(b, opResizeDB, 4, 1)
+
+	/* A string which expires */
+	b = append(b, opExpireTimeMS)
+	b = appendUint64(b, 1700000000000)
+	b = append(b, typeString)
+	b = appendString(b, "s")
+	b = appendString(b, "v")
+
+	b = append(b, opExpireTimeMS)
+	b = appendUint64(b, 1700000000123)
+	b = append(b, opFreq, 5)
+	v := AppendValue(nil, z)
+	b = appendString(append(b, v[0]), "z1")
+	b = append(b, v[1:]...)
+
+	b = append(b, typeListQuicklist2)
+	b = appendString(b, "l")
+	b = append(b, 1, 2)
+	b = appendString(b, strings.Repeat("l", 100))
+
+	/* A stream with a consumer group, a pending entry and a consumer */
+	b = append(b, typeStreamListpacks3)
+	b = appendString(b, "st")
+	b = append(b, 0, 0, 0, 0, 0, 0, 0, 0, 0)
+	b = append(b, 1)
+	b = appendString(b, "g")
+	b = append(b, 0, 0, 0)
+	b = append(b, 1)
+	b = append(b, make([]byte, 16+8)...)
+	b = append(b, 1)
+	b = append(b, 1)
+	b = appendString(b, "c")
+	b = append(b, make([]byte, 8+8)...)
+	b = append(b, 1)
+	b = append(b, make([]byte, 16)...)
+
+	/* Hashes with field expire times, a listpack and a table */
+	b = append(b, typeHashListpackEx)
+	b = appendString(b, "h1")
+	b = appendUint64(b, 1700000000000)
+	b = appendString(b, strings.Repeat("h", 30))
+	b = append(b, typeHashMetadata)
+	b = appendString(b, "h2")
+	b = appendUint64(b, 1700000000000)
+	b = append(b, 2)
+	b = append(b, 0)
+	b = appendString(b, "f1")
+	b = appendString(b, "v1")
+	b = append(b, 0x40, 0xFF)
+	b = appendString(b, "f2")
+	b = appendString(b, "v2")
+
+	b = append(b, opSelectDB, 2)
+	b = append(b, opIdle, 10)
+	b = append(b, TypeZSet)
+	b = appendString(b, "z2")
+	b = append(b, 1)
+	b = appendString(b, "c")
+	b = append(b, 3)
+	b = append(b, "0.5"...)
+
+	b = append(b, opEOF)
+	if checksum {
+		return appendUint64(b, crc64Update(0, b))
+	}
+	return appendUint64(b, 0)
+}
+
+func TestReader(t *testing.T) {
+	data := testFile(true)
+	/* The first byte of the first sorted set */
+	zsetStart := bytes.Index(data, []byte("\x02z1")) - 1
+	r := NewReader(bytes.NewReader(data))
+
+	e, err := r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.DB != 0 || e.Key != "z1" || !e.Expire.Equal(time.UnixMilli(1700000000123)) || e.Set.Length() != 2 {
+		t.Error(e)
+	}
+	if e, err = r.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if e.DB != 2 || e.Key != "z2" || !e.Expire.IsZero() {
+		t.Error(e)
+	}
+	if score, _ := e.Set.GetScore("c"); score != 0.5 {
+		t.Error(score)
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Error(err)
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Error(err)
+	}
+
+	/* Errors */
+	for _, tc := range []struct {
+		data []byte
+		err  error
+	}{
+		{testFile(false), nil},
+		{data[:len(data)-3], io.ErrUnexpectedEOF},
+		{data[:zsetStart+5], io.ErrUnexpectedEOF},
+		{append(append([]byte(nil), data[:len(data)-1]...), data[len(data)-1]^1), zset.ErrChecksum},
+		{[]byte("REDIX0011"), ErrBadHeader},
+		{[]byte("REDIS00x1"), ErrBadHeader},
+		{append(append([]byte(nil), data[:zsetStart]...), opFunctionPre), ErrUnsupported},
+		{append(append([]byte(nil), data[:zsetStart]...), typeHashListpackEx+1), ErrUnsupported},
+	} {
+		var err error
+		for r := NewReader(bytes.NewReader(tc.data)); err == nil; _, err = r.Next() {
+		}
+		if err == io.EOF {
+			err = nil
+		}
+		if err != tc.err {
+			t.Errorf("%q: %v", tc.data[len(tc.data)-5:], err)
+		}
+	}
+}
+
+func TestReadFile(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "dump.rdb")
+	if err := os.WriteFile(name, testFile(true), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Key != "z1" || entries[1].Key != "z2" {
+		t.Error(entries)
+	}
+	if _, err := ReadFile(name + ".missing"); !os.IsNotExist(err) {
+		t.Error(err)
+	}
+}
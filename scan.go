@@ -0,0 +1,225 @@
+package zset
+
+import (
+	"fmt"
+	"sync"
+)
+
+/*-----------------------------------------------------------------------------
+ * Incremental iteration, ZSCAN
+ *----------------------------------------------------------------------------*/
+
+// ScanCursor is the opaque position of a Scan in a SortedSet. It remembers
+// the score and the key of the last element returned instead of a rank,
+// so inserts and deletes elsewhere in the set don't shift it.
+// The zero value starts a new scan.
+type ScanCursor[K comparable, S any] struct {
+	score S
+	key   K
+	// moves is where the scan is in the log of the set's moves.
+	moves   uint64
+	started bool
+}
+
+// IsZero reports whether c is the zero cursor, which starts a scan and
+// is returned by the call which completes it.
+func (c ScanCursor[K, S]) IsZero() bool {
+	return !c.started
+}
+
+// Scan implements ZSCAN. It examines up to count elements from cursor on,
+// in ascending order, and returns the ones matching the glob-style pattern
+// match together with the cursor to continue from. An empty pattern
+// matches everything, and keys which are not strings are matched in their
+// fmt.Sprint form. The scan is complete when the returned cursor IsZero.
+//
+// Only a read lock is held, and only during a call. Every element present
+// for the whole scan is returned at least once: an element whose score
+// changes is moved, and returned again if it is moved behind the cursor.
+// Once scanned, a set remembers the elements moved, up to about as many
+// as it holds; a scan older than that starts over from the first element.
+func (z *SortedSet[K, S]) Scan(cursor ScanCursor[K, S], count int64, match string) (ScanCursor[K, S], []Member[K, S]) {
+	z.lock.RLock()
+	defer z.lock.RUnlock()
+	return z.scan(cursor, count, match)
+}
+
+func (z *SortedSet[K, S]) scan(cursor ScanCursor[K, S], count int64, match string) (ScanCursor[K, S], []Member[K, S]) {
+	if count <= 0 {
+		count = 10
+	}
+	z.moves.start()
+	members := make([]Member[K, S], 0)
+	var x *skipListNode[K, S]
+	if !cursor.started || cursor.moves < z.moves.first {
+		/* A new scan, or some moves were forgotten. */
+		x = z.zsl.header.level[0].forward
+	} else {
+		members = z.movedBehind(cursor, match, members)
+		x = z.zsl.zslFirstAfter(cursor.score, cursor.key)
+	}
+	for ; x != nil && count > 0; x = x.level[0].forward {
+		count--
+		cursor = ScanCursor[K, S]{score: x.score, key: x.objID, started: true}
+		if match == "" || stringMatch(match, keyString(x.objID)) {
+			members = append(members, Member[K, S]{Key: x.objID, Score: x.score})
+		}
+	}
+	if x == nil {
+		return ScanCursor[K, S]{}, members
+	}
+	cursor.moves = z.moves.next()
+	return cursor, members
+}
+
+/* Appends the elements moved since the previous call of the scan which
+ * are now at or behind its cursor, as it would miss them otherwise. */
+func (z *SortedSet[K, S]) movedBehind(cursor ScanCursor[K, S], match string, members []Member[K, S]) []Member[K, S] {
+	last := Member[K, S]{Key: cursor.key, Score: cursor.score}
+	seen := make(map[K]struct{})
+	for _, key := range z.moves.keys[cursor.moves-z.moves.first:] {
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		score, ok := z.dict[key]
+		if !ok {
+			continue
+		}
+		m := Member[K, S]{Key: key, Score: score}
+		if z.compareMembers(m, last) <= 0 && (match == "" || stringMatch(match, keyString(key))) {
+			members = append(members, m)
+		}
+	}
+	return members
+}
+
+/* The log of the keys whose score changed, numbered from first on, so that
+ * a cursor can tell which ones were moved since it was returned. It is only
+ * kept once the set has been scanned, and only the latest moves, about as
+ * many as the elements of the set. */
+type scanLog[K comparable] struct {
+	once  sync.Once
+	on    bool
+	keys  []K
+	first uint64
+}
+
+/* Called by every scan, with a lock held. The first one turns the log on,
+ * writers then see it with the write lock. */
+func (l *scanLog[K]) start() {
+	l.once.Do(func() { l.on = true })
+}
+
+func (l *scanLog[K]) moved(key K, length int64) {
+	if !l.on {
+		return
+	}
+	if n := len(l.keys); int64(n) >= length && n >= 64 {
+		/* Forget the oldest half. */
+		half := n / 2
+		copy(l.keys, l.keys[half:])
+		l.keys = l.keys[:n-half]
+		l.first += uint64(half)
+	}
+	l.keys = append(l.keys, key)
+}
+
+func (l *scanLog[K]) next() uint64 {
+	return l.first + uint64(len(l.keys))
+}
+
+/* Makes every scan start over, when all the elements were replaced. */
+func (l *scanLog[K]) forget() {
+	l.first = l.next() + 1
+	l.keys = l.keys[:0]
+}
+
+func keyString[K comparable](key K) string {
+	if s, ok := any(key).(string); ok {
+		return s
+	}
+	return fmt.Sprint(key)
+}
+
+/* Glob-style pattern matching, as stringmatchlen() in util.c. */
+func stringMatch(pattern, str string) bool {
+	p, s := 0, 0
+	for p < len(pattern) && s < len(str) {
+		switch pattern[p] {
+		case '*':
+			for p+1 < len(pattern) && pattern[p+1] == '*' {
+				p++
+			}
+			if p+1 == len(pattern) {
+				return true /* match */
+			}
+			for ; s < len(str); s++ {
+				if stringMatch(pattern[p+1:], str[s:]) {
+					return true /* match */
+				}
+			}
+			return false /* no match */
+		case '?':
+			s++
+		case '[':
+			p++
+			not := p < len(pattern) && pattern[p] == '^'
+			if not {
+				p++
+			}
+			match := false
+			for {
+				if p >= len(pattern) {
+					p--
+					break
+				}
+				if pattern[p] == '\\' && p+1 < len(pattern) {
+					p++
+					if pattern[p] == str[s] {
+						match = true
+					}
+				} else if pattern[p] == ']' {
+					break
+				} else if p+2 < len(pattern) && pattern[p+1] == '-' {
+					start, end := pattern[p], pattern[p+2]
+					if start > end {
+						start, end = end, start
+					}
+					p += 2
+					if str[s] >= start && str[s] <= end {
+						match = true
+					}
+				} else if pattern[p] == str[s] {
+					match = true
+				}
+				p++
+			}
+			if not {
+				match = !match
+			}
+			if !match {
+				return false /* no match */
+			}
+			s++
+		case '\\':
+			if p+1 < len(pattern) {
+				p++
+			}
+			fallthrough
+		default:
+			if pattern[p] != str[s] {
+				return false /* no match */
+			}
+			s++
+		}
+		p++
+	}
+	if s == len(str) {
+		/* Trailing stars match the empty string too. */
+		for p < len(pattern) && pattern[p] == '*' {
+			p++
+		}
+	}
+	return p == len(pattern) && s == len(str)
+}
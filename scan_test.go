@@ -0,0 +1,149 @@
+package zset
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestStringMatch(t *testing.T) {
+	for _, c := range []struct {
+		pattern, str string
+		match        bool
+	}{
+		{"*", "", true},
+		{"*", "anything", true},
+		{"user:*", "user:42", true},
+		{"user:*", "group:42", false},
+		{"a?c", "abc", true},
+		{"a?c", "ac", false},
+		{"a*c*", "abxcy", true},
+		{"[abc]x", "bx", true},
+		{"[^abc]x", "bx", false},
+		{"[a-c]x", "cx", true},
+		{"[c-a]x", "bx", true},
+		{"\\*x", "*x", true},
+		{"\\*x", "ax", false},
+		{"[\\]]", "]", true},
+		{"a/*", "a/b/c", true},
+		{"abc", "abcd", false},
+	} {
+		if got := stringMatch(c.pattern, c.str); got != c.match {
+			t.Error(c.pattern, c.str, got)
+		}
+	}
+}
+
+func TestScan(t *testing.T) {
+	z := New[string]()
+	for i := 0; i < 100; i++ {
+		z.Set(float64(i%10), fmt.Sprintf("k%02d", i))
+	}
+
+	seen := make(map[string]int)
+	var cursor ScanCursor[string, float64]
+	calls := 0
+	for {
+		var members []Member[string, float64]
+		cursor, members = z.Scan(cursor, 7, "")
+		calls++
+		for _, m := range members {
+			seen[m.Key]++
+		}
+		/* Mutate between the calls, the cursor must survive it. */
+		if calls == 3 {
+			z.Delete("k00")
+			z.Set(-1, "new")
+			z.Set(100, "tail")
+		}
+		if cursor.IsZero() {
+			break
+		}
+	}
+	for i := 1; i < 100; i++ {
+		if k := fmt.Sprintf("k%02d", i); seen[k] != 1 {
+			t.Error(k, seen[k])
+		}
+	}
+	if seen["new"] != 0 || seen["tail"] != 1 {
+		t.Error(seen["new"], seen["tail"])
+	}
+
+	n := 0
+	for cursor, members := z.Scan(ScanCursor[string, float64]{}, 10, "k*5"); ; cursor, members = z.Scan(cursor, 10, "k*5") {
+		n += len(members)
+		if cursor.IsZero() {
+			break
+		}
+	}
+	if n != 10 {
+		t.Error(n)
+	}
+
+	ints := New[int64]()
+	ints.Set(1, 10)
+	ints.Set(2, 21)
+	ints.Set(3, 31)
+	if cursor, members := ints.Scan(ScanCursor[int64, float64]{}, 0, "*1"); !cursor.IsZero() || len(members) != 2 {
+		t.Error(cursor, members)
+	}
+}
+
+func TestScanMoved(t *testing.T) {
+	z := New[string]()
+	for i := 0; i < 100; i++ {
+		z.Set(float64(i), fmt.Sprintf("k%02d", i))
+	}
+	scanAll := func(mutate func(calls int)) map[string]int {
+		seen := make(map[string]int)
+		var cursor ScanCursor[string, float64]
+		for calls := 1; ; calls++ {
+			var members []Member[string, float64]
+			cursor, members = z.Scan(cursor, 10, "")
+			for _, m := range members {
+				seen[m.Key]++
+			}
+			if cursor.IsZero() {
+				return seen
+			}
+			mutate(calls)
+		}
+	}
+
+	/* Elements moved from ahead of the cursor to behind it. */
+	seen := scanAll(func(calls int) {
+		if calls == 2 {
+			z.Set(-1, "k50")
+			z.IncrBy(-60, "k90")
+			z.Set(5.5, "k05")
+			z.Set(200, "k99")
+			z.Set(150, "k99")
+		}
+		if calls == 3 {
+			z.Set(-2, "k95")
+			z.Delete("k96")
+			z.Set(1000, "k50")
+		}
+	})
+	for i := 0; i < 100; i++ {
+		k := fmt.Sprintf("k%02d", i)
+		if k != "k96" && seen[k] == 0 {
+			t.Error(k)
+		}
+	}
+	if seen["k95"] != 1 || seen["k50"] != 2 || seen["k99"] != 1 {
+		t.Error(seen["k95"], seen["k50"], seen["k99"])
+	}
+
+	/* Too many moves to remember, the scan starts over. */
+	seen = scanAll(func(calls int) {
+		if calls == 5 {
+			for i := 0; i < 300; i++ {
+				z.IncrBy(1, "k10")
+			}
+			z.Set(-5, "k80")
+		}
+	})
+	if seen["k80"] == 0 || seen["k00"] != 2 {
+		t.Error(seen["k80"], seen["k00"])
+	}
+}
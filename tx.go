@@ -0,0 +1,414 @@
+package zset
+
+import (
+	"math/rand"
+	"sort"
+)
+
+/*-----------------------------------------------------------------------------
+ * Transactions, several operations under a single lock
+ *----------------------------------------------------------------------------*/
+
+// Tx gives access to a SortedSet inside Update or View, which hold the lock
+// of the set for the whole callback. Its methods are the ones of SortedSet
+// without the locking: they must not be used once the callback returned,
+// and the callback must not call the methods of the locked sets themselves,
+// which would deadlock. The callbacks of ranges are called with the lock
+// held, with the same restrictions.
+type Tx[K comparable, S any] struct {
+	z        *SortedSet[K, S]
+	writable bool
+	// undo keeps the state before the transaction of every modified key.
+	undo map[K]txUndo[S]
+	// removed keys are only passed to onRemove of the set on commit,
+	// so that a SortedMap keeps its values if the transaction is rolled back.
+	removed  []K
+	onRemove func(key K)
+	// onRollback lets a MapTx restore the values.
+	onRollback func()
+}
+
+type txUndo[S any] struct {
+	score S
+	ok    bool
+}
+
+// Update calls fn with a writable Tx over z, holding the write lock of z
+// until fn returns. If fn returns an error or panics, all the changes made
+// through the Tx are rolled back and the error is returned. Blocked pops
+// are only served after the changes are committed.
+func (z *SortedSet[K, S]) Update(fn func(tx *Tx[K, S]) error) error {
+	return UpdateAll([]*SortedSet[K, S]{z}, func(txs []*Tx[K, S]) error {
+		return fn(txs[0])
+	})
+}
+
+// View calls fn with a read-only Tx over z, holding the read lock of z until
+// fn returns, and returns the error of fn. The methods of the Tx which would
+// modify z panic.
+func (z *SortedSet[K, S]) View(fn func(tx *Tx[K, S]) error) error {
+	return ViewAll([]*SortedSet[K, S]{z}, func(txs []*Tx[K, S]) error {
+		return fn(txs[0])
+	})
+}
+
+// UpdateAll is Update over several sets, fn gets a Tx for each of them in
+// the same order. The sets are always locked in the order they were created,
+// so concurrent transactions over the same sets given in any order don't
+// deadlock. A set given more than once gets the same Tx every time.
+func UpdateAll[K comparable, S any](sets []*SortedSet[K, S], fn func(txs []*Tx[K, S]) error) error {
+	return transaction(sets, true, fn)
+}
+
+// ViewAll is View over several sets, see UpdateAll.
+func ViewAll[K comparable, S any](sets []*SortedSet[K, S], fn func(txs []*Tx[K, S]) error) error {
+	return transaction(sets, false, fn)
+}
+
+func transaction[K comparable, S any](sets []*SortedSet[K, S], writable bool, fn func(txs []*Tx[K, S]) error) error {
+	txs := make([]*Tx[K, S], len(sets))
+	byID := make(map[uint64]*Tx[K, S], len(sets))
+	unique := make([]*Tx[K, S], 0, len(sets))
+	for i, z := range sets {
+		tx, ok := byID[z.id]
+		if !ok {
+			tx = &Tx[K, S]{z: z, writable: writable}
+			byID[z.id] = tx
+			unique = append(unique, tx)
+		}
+		txs[i] = tx
+	}
+	sort.Slice(unique, func(i, j int) bool {
+		return unique[i].z.id < unique[j].z.id
+	})
+	for _, tx := range unique {
+		tx.begin()
+	}
+
+	committed := false
+	defer func() {
+		/* Also reached when fn panics, then the changes are rolled back. */
+		if committed {
+			commitJournals(unique)
+		}
+		for i := len(unique) - 1; i >= 0; i-- {
+			unique[i].end(committed)
+		}
+	}()
+	err := fn(txs)
+	committed = err == nil
+	return err
+}
+
+func (tx *Tx[K, S]) begin() {
+	z := tx.z
+	if !tx.writable {
+		z.lock.RLock()
+		return
+	}
+	z.lock.Lock()
+	if z.journal != nil {
+		z.journal.tx = true
+	}
+	tx.undo = make(map[K]txUndo[S])
+	tx.onRemove = z.onRemove
+	z.onRemove = func(key K) {
+		tx.removed = append(tx.removed, key)
+	}
+}
+
+func (tx *Tx[K, S]) end(commit bool) {
+	z := tx.z
+	if !tx.writable {
+		z.lock.RUnlock()
+		return
+	}
+	defer z.lock.Unlock()
+	if s := z.journal; s != nil {
+		s.tx, s.pending, s.commands = false, nil, 0
+	}
+	z.onRemove = tx.onRemove
+	if !commit {
+		tx.rollback()
+		if tx.onRollback != nil {
+			tx.onRollback()
+		}
+		return
+	}
+	if z.onRemove != nil {
+		for _, key := range tx.removed {
+			/* Unless added back meanwhile. */
+			if _, ok := z.dict[key]; !ok {
+				z.onRemove(key)
+			}
+		}
+	}
+	z.serveBlocked()
+}
+
+func (tx *Tx[K, S]) rollback() {
+	z := tx.z
+	for key, u := range tx.undo {
+		if score, ok := z.dict[key]; ok {
+			z.zsl.zslDelete(score, key)
+			delete(z.dict, key)
+		}
+		if u.ok {
+			z.dict[key] = u.score
+			z.zsl.zslInsert(u.score, key)
+		}
+	}
+}
+
+/* Must be called before the first modification of key. */
+func (tx *Tx[K, S]) save(key K) {
+	tx.mustWrite()
+	if _, ok := tx.undo[key]; ok {
+		return
+	}
+	score, ok := tx.z.dict[key]
+	tx.undo[key] = txUndo[S]{score: score, ok: ok}
+}
+
+/* Must be called after members are removed. */
+func (tx *Tx[K, S]) saveRemoved(members []Member[K, S]) {
+	for _, m := range members {
+		if _, ok := tx.undo[m.Key]; !ok {
+			tx.undo[m.Key] = txUndo[S]{score: m.Score, ok: true}
+		}
+	}
+}
+
+func (tx *Tx[K, S]) mustWrite() {
+	if !tx.writable {
+		panic("zset: write in a read-only transaction")
+	}
+}
+
+// Length is SortedSet.Length in a transaction.
+func (tx *Tx[K, S]) Length() int64 {
+	return tx.z.zsl.length
+}
+
+// Set is SortedSet.Set in a transaction.
+func (tx *Tx[K, S]) Set(score S, key K) error {
+	tx.mustWrite()
+	if tx.z.nan != nil && tx.z.nan(score) {
+		return ErrNaN
+	}
+	tx.save(key)
+	tx.z.set(score, key)
+	return nil
+}
+
+// IncrBy is SortedSet.IncrBy in a transaction.
+func (tx *Tx[K, S]) IncrBy(score S, key K) (S, error) {
+	tx.save(key)
+	newScore, _, err := tx.z.add(score, key, INCR)
+	return newScore, err
+}
+
+// Add is SortedSet.Add in a transaction.
+func (tx *Tx[K, S]) Add(score S, key K, flags AddFlag) (S, AddResult, error) {
+	tx.mustWrite()
+	if !validAddFlags(flags) {
+		var zero S
+		return zero, Skipped, ErrAddFlags
+	}
+	tx.save(key)
+	return tx.z.add(score, key, flags)
+}
+
+// Delete is SortedSet.Delete in a transaction.
+func (tx *Tx[K, S]) Delete(key K) bool {
+	tx.save(key)
+	return tx.z.del(key)
+}
+
+// SetMany is SortedSet.SetMany in a transaction.
+func (tx *Tx[K, S]) SetMany(members []Member[K, S]) ([]AddResult, error) {
+	tx.mustWrite()
+	if tx.z.nan != nil {
+		for _, m := range members {
+			if tx.z.nan(m.Score) {
+				return nil, ErrNaN
+			}
+		}
+	}
+	results := make([]AddResult, len(members))
+	for i, m := range members {
+		tx.save(m.Key)
+		results[i] = tx.z.set(m.Score, m.Key)
+	}
+	return results, nil
+}
+
+// IncrByMany is SortedSet.IncrByMany in a transaction.
+func (tx *Tx[K, S]) IncrByMany(members []Member[K, S]) ([]S, error) {
+	scores := make([]S, 0, len(members))
+	for _, m := range members {
+		score, err := tx.IncrBy(m.Score, m.Key)
+		if err != nil {
+			return scores, err
+		}
+		scores = append(scores, score)
+	}
+	return scores, nil
+}
+
+// DeleteMany is SortedSet.DeleteMany in a transaction.
+func (tx *Tx[K, S]) DeleteMany(keys []K) []bool {
+	deleted := make([]bool, len(keys))
+	for i, key := range keys {
+		deleted[i] = tx.Delete(key)
+	}
+	return deleted
+}
+
+// GetScore is SortedSet.GetScore in a transaction.
+func (tx *Tx[K, S]) GetScore(key K) (S, bool) {
+	score, ok := tx.z.dict[key]
+	return score, ok
+}
+
+// LookupScore is SortedSet.LookupScore in a transaction.
+func (tx *Tx[K, S]) LookupScore(key K) (S, error) {
+	score, ok := tx.z.dict[key]
+	if !ok {
+		return score, ErrNotFound
+	}
+	return score, nil
+}
+
+// GetRank is SortedSet.GetRank in a transaction.
+func (tx *Tx[K, S]) GetRank(key K, reverse bool) (int64, S) {
+	return tx.z.getRank(key, reverse)
+}
+
+// LookupRank is SortedSet.LookupRank in a transaction.
+func (tx *Tx[K, S]) LookupRank(key K, reverse bool) (int64, S, error) {
+	rank, score := tx.z.getRank(key, reverse)
+	if rank < 0 {
+		return rank, score, ErrNotFound
+	}
+	return rank, score, nil
+}
+
+// GetDataByRank is SortedSet.GetDataByRank in a transaction.
+func (tx *Tx[K, S]) GetDataByRank(rank int64, reverse bool) (K, S) {
+	return tx.z.getDataByRank(rank, reverse)
+}
+
+// LookupByRank is SortedSet.LookupByRank in a transaction.
+func (tx *Tx[K, S]) LookupByRank(rank int64, reverse bool) (key K, score S, err error) {
+	n := tx.z.nodeByRank(rank, reverse)
+	if n == nil {
+		return key, score, ErrOutOfRange
+	}
+	return n.objID, n.score, nil
+}
+
+// Range is SortedSet.Range in a transaction.
+func (tx *Tx[K, S]) Range(start, end int64, f func(S, K)) {
+	tx.z.commonRange(start, end, false, f)
+}
+
+// RevRange is SortedSet.RevRange in a transaction.
+func (tx *Tx[K, S]) RevRange(start, end int64, f func(S, K)) {
+	tx.z.commonRange(start, end, true, f)
+}
+
+// RangeByScore is SortedSet.RangeByScore in a transaction.
+func (tx *Tx[K, S]) RangeByScore(r ScoreRange[S], offset, count int64, f func(S, K)) {
+	tx.z.commonRangeByScore(r.spec(tx.z.nan), offset, count, false, f)
+}
+
+// RevRangeByScore is SortedSet.RevRangeByScore in a transaction.
+func (tx *Tx[K, S]) RevRangeByScore(r ScoreRange[S], offset, count int64, f func(S, K)) {
+	tx.z.commonRangeByScore(r.spec(tx.z.nan), offset, count, true, f)
+}
+
+// RangeByLex is SortedSet.RangeByLex in a transaction.
+func (tx *Tx[K, S]) RangeByLex(r LexRange[K], offset, count int64, f func(S, K)) {
+	tx.z.commonRangeByLex(r.spec(), offset, count, false, f)
+}
+
+// RevRangeByLex is SortedSet.RevRangeByLex in a transaction.
+func (tx *Tx[K, S]) RevRangeByLex(r LexRange[K], offset, count int64, f func(S, K)) {
+	tx.z.commonRangeByLex(r.spec(), offset, count, true, f)
+}
+
+// Count is SortedSet.Count in a transaction.
+func (tx *Tx[K, S]) Count(r ScoreRange[S]) int64 {
+	return tx.z.count(r)
+}
+
+// LexCount is SortedSet.LexCount in a transaction.
+func (tx *Tx[K, S]) LexCount(r LexRange[K]) int64 {
+	return tx.z.lexCount(r)
+}
+
+// RankOfScore is SortedSet.RankOfScore in a transaction.
+func (tx *Tx[K, S]) RankOfScore(score S, reverse bool) int64 {
+	return tx.z.rankOfScore(score, reverse)
+}
+
+// RemoveRangeByScore is SortedSet.RemoveRangeByScore in a transaction.
+func (tx *Tx[K, S]) RemoveRangeByScore(r ScoreRange[S], f func(S, K)) int64 {
+	tx.mustWrite()
+	removed, members := tx.z.removeRangeByScore(r, true)
+	tx.saveRemoved(members)
+	return callRemoved(removed, members, f)
+}
+
+// RemoveRangeByLex is SortedSet.RemoveRangeByLex in a transaction.
+func (tx *Tx[K, S]) RemoveRangeByLex(r LexRange[K], f func(S, K)) int64 {
+	tx.mustWrite()
+	removed, members := tx.z.removeRangeByLex(r, true)
+	tx.saveRemoved(members)
+	return callRemoved(removed, members, f)
+}
+
+// RemoveRangeByRank is SortedSet.RemoveRangeByRank in a transaction.
+func (tx *Tx[K, S]) RemoveRangeByRank(start, end int64, f func(S, K)) int64 {
+	tx.mustWrite()
+	removed, members := tx.z.removeRangeByRank(start, end, true)
+	tx.saveRemoved(members)
+	return callRemoved(removed, members, f)
+}
+
+// PopMin is SortedSet.PopMin in a transaction.
+func (tx *Tx[K, S]) PopMin(count int64) []Member[K, S] {
+	return tx.pop(count, false)
+}
+
+// PopMax is SortedSet.PopMax in a transaction.
+func (tx *Tx[K, S]) PopMax(count int64) []Member[K, S] {
+	return tx.pop(count, true)
+}
+
+// PopOne is SortedSet.PopOne in a transaction.
+func (tx *Tx[K, S]) PopOne(reverse bool) (key K, score S, err error) {
+	members := tx.pop(1, reverse)
+	if len(members) == 0 {
+		return key, score, ErrEmpty
+	}
+	return members[0].Key, members[0].Score, nil
+}
+
+func (tx *Tx[K, S]) pop(count int64, reverse bool) []Member[K, S] {
+	tx.mustWrite()
+	members := tx.z.pop(count, reverse)
+	tx.saveRemoved(members)
+	return members
+}
+
+// RandMember is SortedSet.RandMember in a transaction.
+func (tx *Tx[K, S]) RandMember(count int64, r *rand.Rand) ([]Member[K, S], error) {
+	return tx.z.randMember(count, r)
+}
+
+// Scan is SortedSet.Scan in a transaction.
+func (tx *Tx[K, S]) Scan(cursor ScanCursor[K, S], count int64, match string) (ScanCursor[K, S], []Member[K, S]) {
+	return tx.z.scan(cursor, count, match)
+}
@@ -0,0 +1,244 @@
+package zset
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestUpdate(t *testing.T) {
+	z := New[string]()
+	z.Set(1, "a")
+	z.Set(2, "b")
+	z.Set(3, "c")
+
+	err := z.Update(func(tx *Tx[string, float64]) error {
+		score, _ := tx.GetScore("a")
+		if _, _, err := tx.Add(score*10, "b", GT); err != nil {
+			return err
+		}
+		tx.Delete("c")
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if score, _ := z.GetScore("b"); score != 10 || z.Length() != 2 {
+		t.Error(score, z.Length())
+	}
+
+	errAbort := errors.New("abort")
+	err = z.Update(func(tx *Tx[string, float64]) error {
+		tx.Set(5, "a")
+		tx.Set(6, "d")
+		tx.Delete("b")
+		tx.Set(7, "b")
+		if n := tx.RemoveRangeByRank(0, 0, nil); n != 1 {
+			t.Error(n)
+		}
+		tx.PopMax(1)
+		if tx.Length() != 1 {
+			t.Error(tx.Length())
+		}
+		return errAbort
+	})
+	if err != errAbort {
+		t.Error(err)
+	}
+	got := ""
+	z.Range(0, -1, func(score float64, k string) {
+		got += k
+	})
+	if got != "ab" {
+		t.Error(got)
+	}
+	if rank, score := z.GetRank("b", false); rank != 1 || score != 10 {
+		t.Error(rank, score)
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("panic not propagated")
+			}
+		}()
+		z.Update(func(tx *Tx[string, float64]) error {
+			tx.DeleteMany([]string{"a", "b"})
+			panic("oops")
+		})
+	}()
+	if z.Length() != 2 {
+		t.Error(z.Length())
+	}
+}
+
+func TestView(t *testing.T) {
+	z := New[string]()
+	z.Set(1, "a")
+	z.Set(2, "b")
+	err := z.View(func(tx *Tx[string, float64]) error {
+		if rank, _, err := tx.LookupRank("b", true); rank != 0 || err != nil {
+			t.Error(rank, err)
+		}
+		if n := tx.Count(ScoreRange[float64]{Min: 0, Max: 1}); n != 1 {
+			t.Error(n)
+		}
+		defer func() {
+			if recover() == nil {
+				t.Error("write allowed")
+			}
+		}()
+		tx.Delete("a")
+		return nil
+	})
+	if err != nil || z.Length() != 2 {
+		t.Error(err, z.Length())
+	}
+}
+
+func TestUpdateAll(t *testing.T) {
+	a, b := New[string](), New[string]()
+	a.Set(1, "x")
+
+	/* Move x from a to b. */
+	move := func(from, to *SortedSet[string, float64]) error {
+		return UpdateAll([]*SortedSet[string, float64]{from, to}, func(txs []*Tx[string, float64]) error {
+			score, err := txs[0].LookupScore("x")
+			if err != nil {
+				return err
+			}
+			txs[0].Delete("x")
+			return txs[1].Set(score, "x")
+		})
+	}
+	if err := move(a, b); err != nil || a.Length() != 0 || b.Length() != 1 {
+		t.Fatal(err, a.Length(), b.Length())
+	}
+	if err := move(a, b); err != ErrNotFound {
+		t.Error(err)
+	}
+
+	/* Opposite orders must not deadlock. */
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			move(a, b)
+		}()
+		go func() {
+			defer wg.Done()
+			move(b, a)
+		}()
+	}
+	wg.Wait()
+	if a.Length()+b.Length() != 1 {
+		t.Error(a.Length(), b.Length())
+	}
+
+	err := UpdateAll([]*SortedSet[string, float64]{a, a}, func(txs []*Tx[string, float64]) error {
+		if txs[0] != txs[1] {
+			t.Error("different Txs for the same set")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestUpdateMap(t *testing.T) {
+	type mapTx = MapTx[string, float64, int]
+	m := NewMap[string, int]()
+	m.Set(1, "a", 10)
+	m.Update(func(tx *mapTx) error {
+		tx.Delete("a")
+		return errors.New("abort")
+	})
+	if v, ok := m.Get("a"); !ok || v != 10 {
+		t.Error(v, ok)
+	}
+	m.Update(func(tx *mapTx) error {
+		tx.Delete("a")
+		return nil
+	})
+	if len(m.values) != 0 {
+		t.Error(m.values)
+	}
+
+	/* Values set in a transaction. */
+	m.Set(1, "a", 10)
+	m.Set(2, "b", 20)
+	err := m.Update(func(tx *mapTx) error {
+		tx.Set(3, "c", 30)
+		if !tx.SetValue("a", 11) || tx.SetValue("missing", 1) {
+			t.Error("SetValue")
+		}
+		tx.Delete("b")
+		tx.Set(4, "b", 21)
+		if v, ok := tx.Get("c"); !ok || v != 30 {
+			t.Error(v, ok)
+		}
+		return errors.New("abort")
+	})
+	if err == nil || m.Length() != 2 || len(m.values) != 2 {
+		t.Error(err, m.Length(), m.values)
+	}
+	if a, _ := m.Get("a"); a != 10 {
+		t.Error(a)
+	}
+	if b, _ := m.Get("b"); b != 20 {
+		t.Error(b)
+	}
+
+	m.Update(func(tx *mapTx) error {
+		tx.Set(3, "c", 30)
+		tx.SetValue("a", 11)
+		tx.Delete("b")
+		tx.Set(4, "b", 21)
+		return nil
+	})
+	for key, want := range map[string]int{"a": 11, "b": 21, "c": 30} {
+		if v, ok := m.Get(key); !ok || v != want {
+			t.Error(key, v, ok)
+		}
+	}
+
+	m.View(func(tx *mapTx) error {
+		if v, ok := tx.Get("b"); !ok || v != 21 {
+			t.Error(v, ok)
+		}
+		defer func() {
+			if recover() == nil {
+				t.Error("SetValue in View")
+			}
+		}()
+		tx.SetValue("b", 0)
+		return nil
+	})
+}
+
+func TestUpdateBlocked(t *testing.T) {
+	z := New[string]()
+	done := make(chan []Member[string, float64], 1)
+	go func() {
+		members, _ := z.BlockingPopMin(context.Background(), 1)
+		done <- members
+	}()
+	waitBlocked(t, z, 1)
+	z.Update(func(tx *Tx[string, float64]) error {
+		tx.Set(1, "a")
+		return errors.New("abort")
+	})
+	z.Update(func(tx *Tx[string, float64]) error {
+		tx.Set(2, "b")
+		if tx.Length() != 1 {
+			t.Error("served before commit")
+		}
+		return nil
+	})
+	if members := <-done; len(members) != 1 || members[0].Key != "b" {
+		t.Error(members)
+	}
+}
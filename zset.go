@@ -31,76 +31,222 @@
 package zset
 
 import (
+	"errors"
+	"math"
 	"math/rand"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/liyiheng/zset/cmp"
 )
 
 const zSkiplistMaxlevel = 32
 
+// ZADD options, they can be combined like NX|INCR.
+const (
+	NX   AddFlag = 1 << iota // Only add new elements, never update
+	XX                       // Only update elements that already exist
+	GT                       // Only update when the new score is greater
+	LT                       // Only update when the new score is less
+	INCR                     // Increment the score like ZINCRBY
+)
+
+// Results of Add.
+const (
+	Unchanged AddResult = iota // The element exists with the same score
+	Added                      // A new element was added
+	Updated                    // The score of an existing element changed
+	Skipped                    // NX, XX, GT or LT prevented the operation
+)
+
+// ErrNotFloat is returned when a score range bound can not be parsed.
+var ErrNotFloat = errors.New("min or max is not a float")
+
+// ErrNaN is returned when a score is, or would become, NaN.
+var ErrNaN = errors.New("resulting score is not a number (NaN)")
+
+// ErrOverflow is returned when incrementing an integer score would overflow.
+var ErrOverflow = errors.New("increment or decrement would overflow")
+
+// ErrNotIncrementable is returned when incrementing the scores of a set
+// made with NewComposite or NewWith.
+var ErrNotIncrementable = errors.New("scores of this set can not be incremented")
+
+// ErrAddFlags is returned by Add for incompatible flags, that is NX
+// together with XX, GT or LT, or GT together with LT.
+var ErrAddFlags = errors.New("GT, LT, NX and XX options at the same time are not compatible")
+
+// ErrNotFound is returned by the Lookup methods when there is no element
+// with the given key.
+var ErrNotFound = errors.New("no such element")
+
+// ErrOutOfRange is returned by LookupByRank when the rank is negative or
+// not lower than the length of the set.
+var ErrOutOfRange = errors.New("rank is out of range")
+
+// ErrCountOutOfRange is returned by RandMember when count is lower than
+// -math.MaxInt64/2 or greater than math.MaxInt64/2, like Redis does.
+var ErrCountOutOfRange = errors.New("count is out of range")
+
+// ErrEmpty is returned by PopOne when the set is empty.
+var ErrEmpty = errors.New("sorted set is empty")
+
+// ErrNotLexRange is returned when a lex range bound can not be parsed.
+var ErrNotLexRange = errors.New("min or max not valid string range item")
+
 type (
-	// Key constraint
+	// Key constraint of New, use NewFunc for other comparable keys
 	Key interface {
 		cmp.Ordered
 	}
-	skipListLevel[K Key] struct {
-		forward *skipListNode[K]
+	// Number constraint of scores
+	Number interface {
+		~int | ~int8 | ~int16 | ~int32 | ~int64 |
+			~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr |
+			~float32 | ~float64
+	}
+	skipListLevel[K comparable, S any] struct {
+		forward *skipListNode[K, S]
 		span    uint64
 	}
 
-	skipListNode[K Key] struct {
+	skipListNode[K comparable, S any] struct {
 		objID    K
-		score    float64
-		backward *skipListNode[K]
-		level    []*skipListLevel[K]
+		score    S
+		backward *skipListNode[K, S]
+		level    []*skipListLevel[K, S]
 	}
 	obj struct {
 		score float64
 	}
 
-	skipList[K Key] struct {
-		header *skipListNode[K]
-		tail   *skipListNode[K]
+	skipList[K comparable, S any] struct {
+		header *skipListNode[K, S]
+		tail   *skipListNode[K, S]
 		length int64
 		level  int16
+		// version changes whenever a node is linked or unlinked, so that
+		// iterations can tell whether the node they stopped at is stale.
+		version uint64
+		// compare orders the keys of elements with the same score.
+		compare      func(a, b K) int
+		compareScore func(a, b S) int
+		// update and rank are scratch buffers for the updates, reused
+		// as the skiplist is only modified with the write lock held.
+		update []*skipListNode[K, S]
+		rank   []uint64
 	}
 	// SortedSet is the final exported sorted set we can use
-	SortedSet[K Key] struct {
-		dict map[K]float64
-		zsl  *skipList[K]
-		lock sync.RWMutex
-	}
-	zrangespec struct {
-		min   float64
-		max   float64
+	SortedSet[K comparable, S any] struct {
+		dict    map[K]S
+		zsl     *skipList[K, S]
+		lock    sync.RWMutex
+		blocked []blockedEntry[K, S]
+		compare func(a, b K) int
+		// compareScore orders the scores, incr and nan are nil
+		// when scores are not numbers.
+		compareScore func(a, b S) int
+		incr         func(score, incr S) (S, error)
+		nan          func(score S) bool
+		// onRemove is called with the write lock held for every
+		// element removed, it lets a SortedMap drop its value.
+		onRemove func(key K)
+		// journal records the changes, see Attach.
+		journal *setJournal[K, S]
+		// moves remembers the elements whose score changed, see Scan.
+		moves scanLog[K]
+		// id orders the locks of several sets, see UpdateAll.
+		id uint64
+	}
+	// Member is an element of a SortedSet along with its score.
+	Member[K comparable, S any] struct {
+		Key   K
+		Score S
+	}
+	// ScoreRange is a score interval as accepted by ZRANGEBYSCORE.
+	// MinEx and MaxEx make the corresponding bound exclusive, which is
+	// what the "(" prefix does in Redis. For float scores, use math.Inf
+	// for -inf and +inf, for integers their minimum and maximum values.
+	// A range with a NaN bound is empty.
+	ScoreRange[S any] struct {
+		Min   S
+		Max   S
+		MinEx bool
+		MaxEx bool
+	}
+	// AddFlag is a ZADD option accepted by Add.
+	AddFlag uint8
+	// AddResult tells what Add did to an element.
+	AddResult         uint8
+	zrangespec[S any] struct {
+		min   S
+		max   S
 		minex int32
 		maxex int32
 	}
-	zlexrangespec[K Key] struct {
+	// LexRange is a key interval as accepted by ZRANGEBYLEX.
+	// MinEx and MaxEx make the corresponding bound exclusive ("(" in Redis),
+	// otherwise it is inclusive ("["). MinInf and MaxInf stand for the
+	// "-" and "+" bounds, in which case Min or Max is ignored.
+	LexRange[K comparable] struct {
+		Min    K
+		Max    K
+		MinEx  bool
+		MaxEx  bool
+		MinInf bool
+		MaxInf bool
+	}
+	zlexrangespec[K comparable] struct {
 		minKey K
 		maxKey K
 		minex  int
 		maxex  int
+		mininf int
+		maxinf int
 	}
 )
 
-func zslCreateNode[K Key](level int16, score float64, id K) *skipListNode[K] {
-	n := &skipListNode[K]{
+// isNaN reports whether x is a NaN, which is only possible for floats.
+func isNaN[S Number](x S) bool {
+	return x != x
+}
+
+// incrScore returns score+incr, or an error instead of a NaN or a wrapped
+// around integer.
+func incrScore[S Number](score, incr S) (S, error) {
+	sum := score + incr
+	if isNaN(sum) {
+		return score, ErrNaN
+	}
+	/* Floats saturate to inf, only integers can get this wrong. */
+	if (incr > 0 && sum < score) || (incr < 0 && sum > score) {
+		return score, ErrOverflow
+	}
+	return sum, nil
+}
+
+func zslCreateNode[K comparable, S any](level int16, score S, id K) *skipListNode[K, S] {
+	n := &skipListNode[K, S]{
 		score: score,
 		objID: id,
-		level: make([]*skipListLevel[K], level),
+		level: make([]*skipListLevel[K, S], level),
 	}
 	for i := range n.level {
-		n.level[i] = new(skipListLevel[K])
+		n.level[i] = new(skipListLevel[K, S])
 	}
 	return n
 }
 
-func zslCreate[K Key]() *skipList[K] {
-	return &skipList[K]{
-		level:  1,
-		header: zslCreateNode[K](zSkiplistMaxlevel, 0, *new(K)),
+func zslCreate[K comparable, S any](compareScore func(a, b S) int, compare func(a, b K) int) *skipList[K, S] {
+	return &skipList[K, S]{
+		level:        1,
+		header:       zslCreateNode[K, S](zSkiplistMaxlevel, *new(S), *new(K)),
+		compare:      compare,
+		compareScore: compareScore,
+		update:       make([]*skipListNode[K, S], zSkiplistMaxlevel),
+		rank:         make([]uint64, zSkiplistMaxlevel),
 	}
 }
 
@@ -124,9 +270,8 @@ func randomLevel() int16 {
 /* zslInsert a new node in the skiplist. Assumes the element does not already
  * exist (up to the caller to enforce that). The skiplist takes ownership
  * of the passed SDS string 'obj'. */
-func (zsl *skipList[K]) zslInsert(score float64, id K) *skipListNode[K] {
-	update := make([]*skipListNode[K], zSkiplistMaxlevel)
-	rank := make([]uint64, zSkiplistMaxlevel)
+func (zsl *skipList[K, S]) zslInsert(score S, id K) *skipListNode[K, S] {
+	update, rank := zsl.update, zsl.rank
 	x := zsl.header
 	for i := zsl.level - 1; i >= 0; i-- {
 		/* store rank that is crossed to reach the insert position */
@@ -137,8 +282,7 @@ func (zsl *skipList[K]) zslInsert(score float64, id K) *skipListNode[K] {
 		}
 		if x.level[i] != nil {
 			for x.level[i].forward != nil &&
-				(x.level[i].forward.score < score ||
-					(x.level[i].forward.score == score && x.level[i].forward.objID < id)) {
+				zsl.zslCompare(x.level[i].forward, score, id) < 0 {
 				rank[i] += x.level[i].span
 				x = x.level[i].forward
 			}
@@ -185,11 +329,48 @@ func (zsl *skipList[K]) zslInsert(score float64, id K) *skipListNode[K] {
 		zsl.tail = x
 	}
 	zsl.length++
+	zsl.version++
 	return x
 }
 
+/* Build the skiplist, which must be empty, from elements already in
+ * ascending order. Nodes are appended to every level from left to right,
+ * remembering the last node of each level and its rank, so that no search
+ * is needed and the whole build is O(N). */
+func (zsl *skipList[K, S]) zslBuild(members []Member[K, S]) {
+	last, rank := zsl.update, zsl.rank
+	for i := range last {
+		last[i] = zsl.header
+		rank[i] = 0
+	}
+	var prev *skipListNode[K, S]
+	for i, m := range members {
+		r := uint64(i + 1)
+		level := randomLevel()
+		if level > zsl.level {
+			zsl.level = level
+		}
+		x := zslCreateNode(level, m.Score, m.Key)
+		for j := int16(0); j < level; j++ {
+			last[j].level[j].forward = x
+			last[j].level[j].span = r - rank[j]
+			last[j], rank[j] = x, r
+		}
+		x.backward = prev
+		prev = x
+	}
+	/* The last node of every level spans up to the end of the list. */
+	n := uint64(len(members))
+	for j := int16(0); j < zsl.level; j++ {
+		last[j].level[j].span = n - rank[j]
+	}
+	zsl.tail = prev
+	zsl.length = int64(n)
+	zsl.version++
+}
+
 /* Internal function used by zslDelete, zslDeleteByScore and zslDeleteByRank */
-func (zsl *skipList[K]) zslDeleteNode(x *skipListNode[K], update []*skipListNode[K]) {
+func (zsl *skipList[K, S]) zslDeleteNode(x *skipListNode[K, S], update []*skipListNode[K, S]) {
 	for i := int16(0); i < zsl.level; i++ {
 		if update[i].level[i].forward == x {
 			update[i].level[i].span += x.level[i].span - 1
@@ -207,6 +388,7 @@ func (zsl *skipList[K]) zslDeleteNode(x *skipListNode[K], update []*skipListNode
 		zsl.level--
 	}
 	zsl.length--
+	zsl.version++
 }
 
 /* Delete an element with matching score/element from the skiplist.
@@ -217,14 +399,12 @@ func (zsl *skipList[K]) zslDeleteNode(x *skipListNode[K], update []*skipListNode
  * it is not freed (but just unlinked) and *node is set to the node pointer,
  * so that it is possible for the caller to reuse the node (including the
  * referenced SDS string at node->obj). */
-func (zsl *skipList[K]) zslDelete(score float64, id K) int {
-	update := make([]*skipListNode[K], zSkiplistMaxlevel)
+func (zsl *skipList[K, S]) zslDelete(score S, id K) int {
+	update := zsl.update
 	x := zsl.header
 	for i := zsl.level - 1; i >= 0; i-- {
 		for x.level[i].forward != nil &&
-			(x.level[i].forward.score < score ||
-				(x.level[i].forward.score == score &&
-					x.level[i].forward.objID < id)) {
+			zsl.zslCompare(x.level[i].forward, score, id) < 0 {
 			x = x.level[i].forward
 		}
 		update[i] = x
@@ -232,40 +412,62 @@ func (zsl *skipList[K]) zslDelete(score float64, id K) int {
 	/* We may have multiple elements with the same score, what we need
 	 * is to find the element with both the right score and object. */
 	x = x.level[0].forward
-	if x != nil && score == x.score && x.objID == id {
+	if x != nil && zsl.compareScore(score, x.score) == 0 && x.objID == id {
 		zsl.zslDeleteNode(x, update)
 		return 1
 	}
 	return 0 /* not found */
 }
 
-func zslValueGteMin(value float64, spec *zrangespec) bool {
+func zslValueGteMin[S any](compare func(a, b S) int, value S, spec *zrangespec[S]) bool {
 	if spec.minex != 0 {
-		return value > spec.min
+		return compare(value, spec.min) > 0
 	}
-	return value >= spec.min
+	return compare(value, spec.min) >= 0
 }
 
-func zslValueLteMax(value float64, spec *zrangespec) bool {
+func zslValueLteMax[S any](compare func(a, b S) int, value S, spec *zrangespec[S]) bool {
 	if spec.maxex != 0 {
-		return value < spec.max
+		return compare(value, spec.max) < 0
 	}
-	return value <= spec.max
+	return compare(value, spec.max) <= 0
+}
+
+/* Parse a score range as in ZRANGEBYSCORE, where "(" before a value
+ * makes that bound exclusive. */
+func zslParseRange(min, max string) (*zrangespec[float64], error) {
+	spec := &zrangespec[float64]{}
+	var err error
+	if strings.HasPrefix(min, "(") {
+		spec.minex = 1
+		min = min[1:]
+	}
+	if strings.HasPrefix(max, "(") {
+		spec.maxex = 1
+		max = max[1:]
+	}
+	if spec.min, err = strconv.ParseFloat(min, 64); err != nil || math.IsNaN(spec.min) {
+		return nil, ErrNotFloat
+	}
+	if spec.max, err = strconv.ParseFloat(max, 64); err != nil || math.IsNaN(spec.max) {
+		return nil, ErrNotFloat
+	}
+	return spec, nil
 }
 
 /* Returns if there is a part of the zset is in range. */
-func (zsl *skipList[K]) zslIsInRange(ran *zrangespec) bool {
+func (zsl *skipList[K, S]) zslIsInRange(ran *zrangespec[S]) bool {
 	/* Test for ranges that will always be empty. */
-	if ran.min > ran.max ||
-		(ran.min == ran.max && (ran.minex != 0 || ran.maxex != 0)) {
+	c := zsl.compareScore(ran.min, ran.max)
+	if c > 0 || (c == 0 && (ran.minex != 0 || ran.maxex != 0)) {
 		return false
 	}
 	x := zsl.tail
-	if x == nil || !zslValueGteMin(x.score, ran) {
+	if x == nil || !zslValueGteMin(zsl.compareScore, x.score, ran) {
 		return false
 	}
 	x = zsl.header.level[0].forward
-	if x == nil || !zslValueLteMax(x.score, ran) {
+	if x == nil || !zslValueLteMax(zsl.compareScore, x.score, ran) {
 		return false
 	}
 	return true
@@ -273,7 +475,7 @@ func (zsl *skipList[K]) zslIsInRange(ran *zrangespec) bool {
 
 /* Find the first node that is contained in the specified range.
  * Returns NULL when no element is contained in the range. */
-func (zsl *skipList[K]) zslFirstInRange(ran *zrangespec) *skipListNode[K] {
+func (zsl *skipList[K, S]) zslFirstInRange(ran *zrangespec[S]) *skipListNode[K, S] {
 	/* If everything is out of range, return early. */
 	if !zsl.zslIsInRange(ran) {
 		return nil
@@ -283,7 +485,7 @@ func (zsl *skipList[K]) zslFirstInRange(ran *zrangespec) *skipListNode[K] {
 	for i := zsl.level - 1; i >= 0; i-- {
 		/* Go forward while *OUT* of range. */
 		for x.level[i].forward != nil &&
-			!zslValueGteMin(x.level[i].forward.score, ran) {
+			!zslValueGteMin(zsl.compareScore, x.level[i].forward.score, ran) {
 			x = x.level[i].forward
 		}
 	}
@@ -292,7 +494,7 @@ func (zsl *skipList[K]) zslFirstInRange(ran *zrangespec) *skipListNode[K] {
 	//serverAssert(x != NULL);
 
 	/* Check if score <= max. */
-	if !zslValueLteMax(x.score, ran) {
+	if !zslValueLteMax(zsl.compareScore, x.score, ran) {
 		return nil
 	}
 	return x
@@ -300,7 +502,7 @@ func (zsl *skipList[K]) zslFirstInRange(ran *zrangespec) *skipListNode[K] {
 
 /* Find the last node that is contained in the specified range.
  * Returns NULL when no element is contained in the range. */
-func (zsl *skipList[K]) zslLastInRange(ran *zrangespec) *skipListNode[K] {
+func (zsl *skipList[K, S]) zslLastInRange(ran *zrangespec[S]) *skipListNode[K, S] {
 
 	/* If everything is out of range, return early. */
 	if !zsl.zslIsInRange(ran) {
@@ -310,7 +512,7 @@ func (zsl *skipList[K]) zslLastInRange(ran *zrangespec) *skipListNode[K] {
 	for i := zsl.level - 1; i >= 0; i-- {
 		/* Go forward while *IN* range. */
 		for x.level[i].forward != nil &&
-			zslValueLteMax(x.level[i].forward.score, ran) {
+			zslValueLteMax(zsl.compareScore, x.level[i].forward.score, ran) {
 			x = x.level[i].forward
 		}
 	}
@@ -318,7 +520,7 @@ func (zsl *skipList[K]) zslLastInRange(ran *zrangespec) *skipListNode[K] {
 	//serverAssert(x != NULL);
 
 	/* Check if score >= min. */
-	if !zslValueGteMin(x.score, ran) {
+	if !zslValueGteMin(zsl.compareScore, x.score, ran) {
 		return nil
 	}
 	return x
@@ -328,17 +530,17 @@ func (zsl *skipList[K]) zslLastInRange(ran *zrangespec) *skipListNode[K] {
  * Min and max are inclusive, so a score >= min || score <= max is deleted.
  * Note that this function takes the reference to the hash table view of the
  * sorted set, in order to remove the elements from the hash table too. */
-func (zsl *skipList[K]) zslDeleteRangeByScore(ran *zrangespec, dict map[K]float64) uint64 {
+func (zsl *skipList[K, S]) zslDeleteRangeByScore(ran *zrangespec[S], dict map[K]S) uint64 {
 	removed := uint64(0)
-	update := make([]*skipListNode[K], zSkiplistMaxlevel)
+	update := zsl.update
 	x := zsl.header
 	for i := zsl.level - 1; i >= 0; i-- {
 		for x.level[i].forward != nil {
 			var condition bool
 			if ran.minex != 0 {
-				condition = x.level[i].forward.score <= ran.min
+				condition = zsl.compareScore(x.level[i].forward.score, ran.min) <= 0
 			} else {
-				condition = x.level[i].forward.score < ran.min
+				condition = zsl.compareScore(x.level[i].forward.score, ran.min) < 0
 			}
 			if !condition {
 				break
@@ -355,9 +557,9 @@ func (zsl *skipList[K]) zslDeleteRangeByScore(ran *zrangespec, dict map[K]float6
 	for x != nil {
 		var condition bool
 		if ran.maxex != 0 {
-			condition = x.score < ran.max
+			condition = zsl.compareScore(x.score, ran.max) < 0
 		} else {
-			condition = x.score <= ran.max
+			condition = zsl.compareScore(x.score, ran.max) <= 0
 		}
 		if !condition {
 			break
@@ -374,59 +576,99 @@ func (zsl *skipList[K]) zslDeleteRangeByScore(ran *zrangespec, dict map[K]float6
 	return removed
 }
 
-func (zsl *skipList[K]) zslDeleteRangeByLex(ran *zlexrangespec[K], dict map[K]float64) uint64 {
-	removed := uint64(0)
+func zslLexValueGteMin[K comparable](compare func(a, b K) int, id K, spec *zlexrangespec[K]) bool {
+	if spec.mininf != 0 {
+		return true
+	}
+	if spec.minex != 0 {
+		return compare(id, spec.minKey) > 0
+	}
+	return compare(id, spec.minKey) >= 0
+}
+
+func zslLexValueLteMax[K comparable](compare func(a, b K) int, id K, spec *zlexrangespec[K]) bool {
+	if spec.maxinf != 0 {
+		return true
+	}
+	if spec.maxex != 0 {
+		return compare(id, spec.maxKey) < 0
+	}
+	return compare(id, spec.maxKey) <= 0
+}
 
-	update := make([]*skipListNode[K], zSkiplistMaxlevel)
+/* Returns if there is a part of the zset is in the lex range. */
+func (zsl *skipList[K, S]) zslIsInLexRange(ran *zlexrangespec[K]) bool {
+	/* Test for ranges that will always be empty. */
+	if ran.mininf == 0 && ran.maxinf == 0 {
+		c := zsl.compare(ran.minKey, ran.maxKey)
+		if c > 0 || (c == 0 && (ran.minex != 0 || ran.maxex != 0)) {
+			return false
+		}
+	}
+	x := zsl.tail
+	if x == nil || !zslLexValueGteMin(zsl.compare, x.objID, ran) {
+		return false
+	}
+	x = zsl.header.level[0].forward
+	if x == nil || !zslLexValueLteMax(zsl.compare, x.objID, ran) {
+		return false
+	}
+	return true
+}
+
+/* Find the first node that is contained in the specified lex range.
+ * Returns NULL when no element is contained in the range. */
+func (zsl *skipList[K, S]) zslFirstInLexRange(ran *zlexrangespec[K]) *skipListNode[K, S] {
+	/* If everything is out of range, return early. */
+	if !zsl.zslIsInLexRange(ran) {
+		return nil
+	}
 	x := zsl.header
 	for i := zsl.level - 1; i >= 0; i-- {
-		for x.level[i].forward != nil && !zslLexValueGteMin(x.level[i].forward.objID, ran) {
+		/* Go forward while *OUT* of range. */
+		for x.level[i].forward != nil &&
+			!zslLexValueGteMin(zsl.compare, x.level[i].forward.objID, ran) {
 			x = x.level[i].forward
 		}
-		update[i] = x
 	}
-
-	/* Current node is the last with score < or <= min. */
+	/* This is an inner range, so the next node cannot be NULL. */
 	x = x.level[0].forward
 
-	/* Delete nodes while in range. */
-	for x != nil && zslLexValueLteMax(x.objID, ran) {
-		next := x.level[0].forward
-		zsl.zslDeleteNode(x, update)
-		delete(dict, x.objID)
-		removed++
-		x = next
+	/* Check if element <= max. */
+	if !zslLexValueLteMax(zsl.compare, x.objID, ran) {
+		return nil
 	}
-	return removed
+	return x
 }
 
-func zslLexValueGteMin[K Key](id K, spec *zlexrangespec[K]) bool {
-	if spec.minex != 0 {
-		return compareKey(id, spec.minKey) > 0
+/* Find the last node that is contained in the specified lex range.
+ * Returns NULL when no element is contained in the range. */
+func (zsl *skipList[K, S]) zslLastInLexRange(ran *zlexrangespec[K]) *skipListNode[K, S] {
+	/* If everything is out of range, return early. */
+	if !zsl.zslIsInLexRange(ran) {
+		return nil
 	}
-	return compareKey(id, spec.minKey) >= 0
-}
-
-func compareKey[K Key](a, b K) int8 {
-	if a == b {
-		return 0
-	} else if a > b {
-		return 1
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		/* Go forward while *IN* range. */
+		for x.level[i].forward != nil &&
+			zslLexValueLteMax(zsl.compare, x.level[i].forward.objID, ran) {
+			x = x.level[i].forward
+		}
 	}
-	return -1
-}
+	/* This is an inner range, so this node cannot be NULL. */
 
-func zslLexValueLteMax[K Key](id K, spec *zlexrangespec[K]) bool {
-	if spec.maxex != 0 {
-		return compareKey(id, spec.maxKey) < 0
+	/* Check if element >= min. */
+	if !zslLexValueGteMin(zsl.compare, x.objID, ran) {
+		return nil
 	}
-	return compareKey(id, spec.maxKey) <= 0
+	return x
 }
 
 /* Delete all the elements with rank between start and end from the skiplist.
  * Start and end are inclusive. Note that start and end need to be 1-based */
-func (zsl *skipList[K]) zslDeleteRangeByRank(start, end uint64, dict map[K]float64) uint64 {
-	update := make([]*skipListNode[K], zSkiplistMaxlevel)
+func (zsl *skipList[K, S]) zslDeleteRangeByRank(start, end uint64, dict map[K]S) uint64 {
+	update := zsl.update
 	var traversed, removed uint64
 
 	x := zsl.header
@@ -451,32 +693,88 @@ func (zsl *skipList[K]) zslDeleteRangeByRank(start, end uint64, dict map[K]float
 	return removed
 }
 
+/* Compare a node with the element of the given score and key,
+ * by score first and then by key. */
+func (zsl *skipList[K, S]) zslCompare(x *skipListNode[K, S], score S, key K) int {
+	if c := zsl.compareScore(x.score, score); c != 0 {
+		return c
+	}
+	return zsl.compare(x.objID, key)
+}
+
 /* Find the rank for an element by both score and obj.
  * Returns 0 when the element cannot be found, rank otherwise.
  * Note that the rank is 1-based due to the span of zsl->header to the
  * first element. */
-func (zsl *skipList[K]) zslGetRank(score float64, key K) int64 {
+func (zsl *skipList[K, S]) zslGetRank(score S, key K) int64 {
 	rank := uint64(0)
 	x := zsl.header
 	for i := zsl.level - 1; i >= 0; i-- {
 		for x.level[i].forward != nil &&
-			(x.level[i].forward.score < score ||
-				(x.level[i].forward.score == score &&
-					x.level[i].forward.objID <= key)) {
+			zsl.zslCompare(x.level[i].forward, score, key) <= 0 {
 			rank += x.level[i].span
 			x = x.level[i].forward
 		}
 
 		/* x might be equal to zsl->header, so test if obj is non-NULL */
-		if x.objID == key {
+		if x != zsl.header && x.objID == key {
 			return int64(rank)
 		}
 	}
 	return 0
 }
 
+/* Count the elements with a score less than the given one, or less than
+ * or equal to it when lte is true. */
+func (zsl *skipList[K, S]) zslCountLess(score S, lte bool) uint64 {
+	rank := uint64(0)
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil {
+			c := zsl.compareScore(x.level[i].forward.score, score)
+			if c > 0 || (c == 0 && !lte) {
+				break
+			}
+			rank += x.level[i].span
+			x = x.level[i].forward
+		}
+	}
+	return rank
+}
+
+/* Find the first node after the element with the given score and key,
+ * whether it is still in the skiplist or not.
+ * Returns NULL when there is no such node. */
+func (zsl *skipList[K, S]) zslFirstAfter(score S, key K) *skipListNode[K, S] {
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil &&
+			zsl.zslCompare(x.level[i].forward, score, key) <= 0 {
+			x = x.level[i].forward
+		}
+	}
+	return x.level[0].forward
+}
+
+/* Find the last node before the element with the given score and key,
+ * whether it is still in the skiplist or not.
+ * Returns NULL when there is no such node. */
+func (zsl *skipList[K, S]) zslLastBefore(score S, key K) *skipListNode[K, S] {
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil &&
+			zsl.zslCompare(x.level[i].forward, score, key) < 0 {
+			x = x.level[i].forward
+		}
+	}
+	if x == zsl.header {
+		return nil
+	}
+	return x
+}
+
 /* Finds an element by its rank. The rank argument needs to be 1-based. */
-func (zsl *skipList[K]) zslGetElementByRank(rank uint64) *skipListNode[K] {
+func (zsl *skipList[K, S]) zslGetElementByRank(rank uint64) *skipListNode[K, S] {
 	traversed := uint64(0)
 	x := zsl.header
 	for i := zsl.level - 1; i >= 0; i-- {
@@ -495,81 +793,290 @@ func (zsl *skipList[K]) zslGetElementByRank(rank uint64) *skipListNode[K] {
  * Common sorted set API
  *----------------------------------------------------------------------------*/
 
-// New creates a new SortedSet and return its pointer
-func New[K Key]() *SortedSet[K] {
-	s := &SortedSet[K]{
-		dict: make(map[K]float64),
-		zsl:  zslCreate[K](),
-		lock: sync.RWMutex{},
+// New creates a new SortedSet with float64 scores and return its pointer
+func New[K Key]() *SortedSet[K, float64] {
+	return NewFuncOf[K, float64](cmp.Compare[K])
+}
+
+// NewFunc creates a new SortedSet with float64 scores for any comparable
+// key type, with compare ordering the keys of elements with the same score,
+// and the keys in the lex range methods. compare(a, b) must return a negative
+// number when a < b, a positive number when a > b and zero only when a == b.
+func NewFunc[K comparable](compare func(a, b K) int) *SortedSet[K, float64] {
+	return NewFuncOf[K, float64](compare)
+}
+
+// NewOf is New with scores of type S, e.g. int64 to keep integers above
+// 2^53 exact.
+func NewOf[K Key, S Number]() *SortedSet[K, S] {
+	return NewFuncOf[K, S](cmp.Compare[K])
+}
+
+// NewFuncOf is NewFunc with scores of type S.
+func NewFuncOf[K comparable, S Number](compare func(a, b K) int) *SortedSet[K, S] {
+	s := NewWith[K, S](cmp.Compare[S], compare)
+	s.incr = incrScore[S]
+	s.nan = isNaN[S]
+	return s
+}
+
+// Comparer is implemented by composite scores, e.g. a struct ranking by
+// points and then by time. Compare must return a negative number, zero or
+// a positive number when the receiver is less than, equal to or greater
+// than the argument, and zero only when both are equal.
+type Comparer[S any] interface {
+	Compare(other S) int
+}
+
+// NewComposite creates a new SortedSet whose scores order themselves with
+// their Compare method, so multi-criteria rankings stay exact instead of
+// being packed into a float64. Such scores can't be incremented: IncrBy
+// and Add with INCR return ErrNotIncrementable.
+func NewComposite[K Key, S Comparer[S]]() *SortedSet[K, S] {
+	return NewWith[K, S](func(a, b S) int { return a.Compare(b) }, cmp.Compare[K])
+}
+
+// NewWith creates a new SortedSet of any score type, ordering the scores
+// with compareScore and the keys of elements with the same score with
+// compare. Both follow the contract of NewFunc. Scores can't be incremented,
+// like in NewComposite.
+func NewWith[K comparable, S any](compareScore func(a, b S) int, compare func(a, b K) int) *SortedSet[K, S] {
+	s := &SortedSet[K, S]{
+		dict:         make(map[K]S),
+		zsl:          zslCreate[K, S](compareScore, compare),
+		lock:         sync.RWMutex{},
+		compare:      compare,
+		compareScore: compareScore,
+		id:           atomic.AddUint64(&lastID, 1),
 	}
 	return s
 }
 
+/* The id of the last SortedSet created. */
+var lastID uint64
+
+// empty returns a new SortedSet configured like z.
+func (z *SortedSet[K, S]) empty() *SortedSet[K, S] {
+	s := NewWith[K, S](z.compareScore, z.compare)
+	s.incr = z.incr
+	s.nan = z.nan
+	return s
+}
+
 // Length returns counts of elements
-func (z *SortedSet[K]) Length() int64 {
+func (z *SortedSet[K, S]) Length() int64 {
 	z.lock.RLock()
 	defer z.lock.RUnlock()
 	return z.zsl.length
 }
 
-// Set is used to add or update an element
-func (z *SortedSet[K]) Set(score float64, key K) {
+// Set is used to add or update an element. A NaN score is rejected
+// with ErrNaN, while -inf and +inf sort before and after any other
+// score, ties being broken by the key as usual.
+func (z *SortedSet[K, S]) Set(score S, key K) error {
+	if z.nan != nil && z.nan(score) {
+		return ErrNaN
+	}
 	z.lock.Lock()
 	defer z.lock.Unlock()
+	if z.set(score, key) == Added {
+		z.serveBlocked()
+	}
+	return nil
+}
+
+/* Must be called with the write lock held, and followed by serveBlocked
+ * when the element is Added. */
+func (z *SortedSet[K, S]) set(score S, key K) AddResult {
 	v, ok := z.dict[key]
 	z.dict[key] = score
-	if ok {
-		/* Remove and re-insert when score changes. */
-		if score != v {
-			z.zsl.zslDelete(v, key)
-			z.zsl.zslInsert(score, key)
-		}
-	} else {
+	if !ok {
 		z.zsl.zslInsert(score, key)
+		z.journalAdd(score, key)
+		return Added
+	}
+	/* Remove and re-insert when score changes. */
+	if z.compareScore(score, v) == 0 {
+		return Unchanged
+	}
+	z.zsl.zslDelete(v, key)
+	z.zsl.zslInsert(score, key)
+	z.moves.moved(key, z.zsl.length)
+	z.journalAdd(score, key)
+	return Updated
+}
+
+// SetMany is Set for several elements under a single lock, in order.
+// It returns what happened to every element, as Add would, or ErrNaN
+// without changing anything if one of the scores is NaN.
+func (z *SortedSet[K, S]) SetMany(members []Member[K, S]) ([]AddResult, error) {
+	if z.nan != nil {
+		for _, m := range members {
+			if z.nan(m.Score) {
+				return nil, ErrNaN
+			}
+		}
+	}
+	results := make([]AddResult, len(members))
+	z.lock.Lock()
+	defer z.lock.Unlock()
+	for i, m := range members {
+		results[i] = z.set(m.Score, m.Key)
 	}
+	z.serveBlocked()
+	return results, nil
 }
 
-// IncrBy ..
-func (z *SortedSet[K]) IncrBy(score float64, key K) float64 {
+// IncrByMany is IncrBy for several elements under a single lock, in order.
+// It stops at the first error, returning it along with the new scores of
+// the elements incremented before.
+func (z *SortedSet[K, S]) IncrByMany(members []Member[K, S]) ([]S, error) {
+	scores := make([]S, 0, len(members))
 	z.lock.Lock()
 	defer z.lock.Unlock()
-	oldScore, ok := z.dict[key]
+	defer z.serveBlocked()
+	for _, m := range members {
+		score, _, err := z.add(m.Score, m.Key, INCR)
+		if err != nil {
+			return scores, err
+		}
+		scores = append(scores, score)
+	}
+	return scores, nil
+}
+
+// IncrBy implements ZINCRBY and returns the new score of the element.
+// Integer scores don't wrap around, ErrOverflow is returned instead,
+// and float scores return ErrNaN when adding -inf to +inf.
+func (z *SortedSet[K, S]) IncrBy(score S, key K) (S, error) {
+	z.lock.Lock()
+	defer z.lock.Unlock()
+	newScore, result, err := z.add(score, key, INCR)
+	if result == Added {
+		z.serveBlocked()
+	}
+	return newScore, err
+}
+
+// Add implements ZADD with the NX, XX, GT, LT and INCR options.
+// It returns the score of the element after the call, unless the result
+// is Skipped, and the error is ErrNaN if the score or the incremented
+// score is not a number, or ErrOverflow if the increment overflows.
+// CH is covered by the result: Added and Updated both count as changed.
+func (z *SortedSet[K, S]) Add(score S, key K, flags AddFlag) (newScore S, result AddResult, err error) {
+	if !validAddFlags(flags) {
+		return newScore, Skipped, ErrAddFlags
+	}
+	z.lock.Lock()
+	defer z.lock.Unlock()
+	newScore, result, err = z.add(score, key, flags)
+	if result == Added {
+		z.serveBlocked()
+	}
+	return newScore, result, err
+}
+
+func validAddFlags(flags AddFlag) bool {
+	nx := flags&NX != 0
+	xx := flags&XX != 0
+	gt := flags&GT != 0
+	lt := flags&LT != 0
+	return !((nx && xx) || (nx && (gt || lt)) || (gt && lt))
+}
+
+func (z *SortedSet[K, S]) add(score S, key K, flags AddFlag) (S, AddResult, error) {
+	var zero S
+	if z.nan != nil && z.nan(score) {
+		return zero, Skipped, ErrNaN
+	}
+	if flags&INCR != 0 && z.incr == nil {
+		return zero, Skipped, ErrNotIncrementable
+	}
+	curScore, ok := z.dict[key]
 	if !ok {
-		z.Set(score, key)
-		return score
+		if flags&XX != 0 {
+			return zero, Skipped, nil
+		}
+		z.dict[key] = score
+		z.zsl.zslInsert(score, key)
+		z.journalAdd(score, key)
+		return score, Added, nil
+	}
+	if flags&NX != 0 {
+		return curScore, Skipped, nil
 	}
-	if score != 0 {
-		z.zsl.zslDelete(oldScore, key)
-		z.dict[key] += score
-		z.zsl.zslInsert(z.dict[key], key)
+	/* Prepare the score for the increment if needed. */
+	if flags&INCR != 0 {
+		var err error
+		if score, err = z.incr(curScore, score); err != nil {
+			return curScore, Skipped, err
+		}
 	}
-	return z.dict[key]
+	/* GT/LT? Only update if score is greater/less than current. */
+	c := z.compareScore(score, curScore)
+	if (flags&LT != 0 && c >= 0) || (flags&GT != 0 && c <= 0) {
+		return curScore, Skipped, nil
+	}
+	/* Remove and re-insert when score changes. */
+	if c == 0 {
+		return score, Unchanged, nil
+	}
+	z.zsl.zslDelete(curScore, key)
+	z.zsl.zslInsert(score, key)
+	z.dict[key] = score
+	z.moves.moved(key, z.zsl.length)
+	z.journalAdd(score, key)
+	return score, Updated, nil
 }
 
 // Delete removes an element from the SortedSet
 // by its key.
-func (z *SortedSet[K]) Delete(key K) (ok bool) {
+func (z *SortedSet[K, S]) Delete(key K) (ok bool) {
 	z.lock.Lock()
 	defer z.lock.Unlock()
+	return z.del(key)
+}
+
+func (z *SortedSet[K, S]) del(key K) bool {
 	score, ok := z.dict[key]
 	if ok {
 		z.zsl.zslDelete(score, key)
 		delete(z.dict, key)
+		z.journalDelete(key)
+		if z.onRemove != nil {
+			z.onRemove(key)
+		}
 		return true
 	}
 	return false
 }
 
-// GetRank returns position,score and extra data of an element which
+// DeleteMany is Delete for several keys under a single lock, it reports
+// for every key whether an element was removed.
+func (z *SortedSet[K, S]) DeleteMany(keys []K) []bool {
+	deleted := make([]bool, len(keys))
+	z.lock.Lock()
+	defer z.lock.Unlock()
+	for i, key := range keys {
+		deleted[i] = z.del(key)
+	}
+	return deleted
+}
+
+// GetRank returns the position and the score of an element which
 // found by the parameter key.
 // The parameter reverse determines the rank is descent or ascend，
 // true means descend and false means ascend.
-func (z *SortedSet[K]) GetRank(key K, reverse bool) (rank int64, score float64) {
+func (z *SortedSet[K, S]) GetRank(key K, reverse bool) (rank int64, score S) {
 	z.lock.RLock()
 	defer z.lock.RUnlock()
+	return z.getRank(key, reverse)
+}
+
+func (z *SortedSet[K, S]) getRank(key K, reverse bool) (rank int64, score S) {
 	score, ok := z.dict[key]
 	if !ok {
-		return -1, 0
+		return -1, score
 	}
 	r := z.zsl.zslGetRank(score, key)
 	if reverse {
@@ -582,54 +1089,91 @@ func (z *SortedSet[K]) GetRank(key K, reverse bool) (rank int64, score float64)
 }
 
 // GetScore implements ZScore
-func (z *SortedSet[K]) GetScore(key K) (score float64, ok bool) {
+func (z *SortedSet[K, S]) GetScore(key K) (score S, ok bool) {
 	z.lock.RLock()
 	defer z.lock.RUnlock()
 	score, ok = z.dict[key]
 	return score, ok
 }
 
-// GetDataByRank returns the id,score and extra data of an element which
+// GetDataByRank returns the id and the score of an element which
 // found by position in the rank.
 // The parameter rank is the position, reverse says if in the descend rank.
-func (z *SortedSet[K]) GetDataByRank(rank int64, reverse bool) (key K, score float64) {
+func (z *SortedSet[K, S]) GetDataByRank(rank int64, reverse bool) (key K, score S) {
 	z.lock.RLock()
 	defer z.lock.RUnlock()
-	if rank < 0 || rank > z.zsl.length {
-		return *new(K), 0
+	return z.getDataByRank(rank, reverse)
+}
+
+func (z *SortedSet[K, S]) getDataByRank(rank int64, reverse bool) (key K, score S) {
+	n := z.nodeByRank(rank, reverse)
+	if n == nil {
+		return key, score
+	}
+	return n.objID, n.score
+}
+
+/* Returns the node at the 0-based rank, or nil if out of range. */
+func (z *SortedSet[K, S]) nodeByRank(rank int64, reverse bool) *skipListNode[K, S] {
+	if rank < 0 || rank >= z.zsl.length {
+		return nil
 	}
 	if reverse {
 		rank = z.zsl.length - rank
 	} else {
 		rank++
 	}
-	n := z.zsl.zslGetElementByRank(uint64(rank))
-	if n == nil {
-		return *new(K), 0
-	}
-	score, ok := z.dict[n.objID]
+	return z.zsl.zslGetElementByRank(uint64(rank))
+}
+
+// LookupRank is GetRank returning ErrNotFound instead of -1
+// when there is no element with the key.
+func (z *SortedSet[K, S]) LookupRank(key K, reverse bool) (int64, S, error) {
+	rank, score := z.GetRank(key, reverse)
+	if rank < 0 {
+		return rank, score, ErrNotFound
+	}
+	return rank, score, nil
+}
+
+// LookupScore is GetScore returning ErrNotFound when there is no
+// element with the key.
+func (z *SortedSet[K, S]) LookupScore(key K) (S, error) {
+	score, ok := z.GetScore(key)
 	if !ok {
-		return *new(K), 0
+		return score, ErrNotFound
 	}
-	return n.objID, score
+	return score, nil
+}
+
+// LookupByRank is GetDataByRank returning ErrOutOfRange when there is no
+// element at the rank, so a zero key with a zero score is told apart.
+func (z *SortedSet[K, S]) LookupByRank(rank int64, reverse bool) (key K, score S, err error) {
+	z.lock.RLock()
+	defer z.lock.RUnlock()
+	n := z.nodeByRank(rank, reverse)
+	if n == nil {
+		return key, score, ErrOutOfRange
+	}
+	return n.objID, n.score, nil
 }
 
 // Range implements ZRANGE
-func (z *SortedSet[K]) Range(start, end int64, f func(float64, K)) {
+func (z *SortedSet[K, S]) Range(start, end int64, f func(S, K)) {
 	z.snapshotRange(start, end, false, f)
 }
 
 // RevRange implements ZREVRANGE
-func (z *SortedSet[K]) RevRange(start, end int64, f func(float64, K)) {
+func (z *SortedSet[K, S]) RevRange(start, end int64, f func(S, K)) {
 	z.snapshotRange(start, end, true, f)
 }
 
-func (z *SortedSet[K]) snapshotRange(start, end int64, reverse bool, f func(float64, K)) {
-	scores := make([]float64, 0)
+func (z *SortedSet[K, S]) snapshotRange(start, end int64, reverse bool, f func(S, K)) {
+	scores := make([]S, 0)
 	keys := make([]K, 0)
 
 	z.lock.RLock()
-	z.commonRange(start, end, reverse, func(f float64, k K) {
+	z.commonRange(start, end, reverse, func(f S, k K) {
 		scores = append(scores, f)
 		keys = append(keys, k)
 	})
@@ -640,7 +1184,7 @@ func (z *SortedSet[K]) snapshotRange(start, end int64, reverse bool, f func(floa
 	}
 }
 
-func (z *SortedSet[K]) commonRange(start, end int64, reverse bool, f func(float64, K)) {
+func (z *SortedSet[K, S]) commonRange(start, end int64, reverse bool, f func(S, K)) {
 	l := z.zsl.length
 	if start < 0 {
 		start += l
@@ -660,7 +1204,7 @@ func (z *SortedSet[K]) commonRange(start, end int64, reverse bool, f func(float6
 	}
 	span := (end - start) + 1
 
-	var node *skipListNode[K]
+	var node *skipListNode[K, S]
 	if reverse {
 		node = z.zsl.tail
 		if start > 0 {
@@ -684,3 +1228,601 @@ func (z *SortedSet[K]) commonRange(start, end int64, reverse bool, f func(float6
 		}
 	}
 }
+
+// ParseScoreRange parses min and max the way ZRANGEBYSCORE does,
+// e.g. "(1.5", "-inf" or "+inf".
+func ParseScoreRange(min, max string) (ScoreRange[float64], error) {
+	spec, err := zslParseRange(min, max)
+	if err != nil {
+		return ScoreRange[float64]{}, err
+	}
+	return ScoreRange[float64]{
+		Min:   spec.min,
+		Max:   spec.max,
+		MinEx: spec.minex != 0,
+		MaxEx: spec.maxex != 0,
+	}, nil
+}
+
+/* A range with a NaN bound is empty, nan is nil if S has no NaN. */
+func (r ScoreRange[S]) spec(nan func(S) bool) *zrangespec[S] {
+	if nan != nil && (nan(r.Min) || nan(r.Max)) {
+		return &zrangespec[S]{minex: 1, maxex: 1}
+	}
+	spec := &zrangespec[S]{min: r.Min, max: r.Max}
+	if r.MinEx {
+		spec.minex = 1
+	}
+	if r.MaxEx {
+		spec.maxex = 1
+	}
+	return spec
+}
+
+// RangeByScore implements ZRANGEBYSCORE with LIMIT offset count.
+// A negative count returns all the elements from offset on.
+func (z *SortedSet[K, S]) RangeByScore(r ScoreRange[S], offset, count int64, f func(S, K)) {
+	z.snapshotRangeByScore(r, offset, count, false, f)
+}
+
+// RevRangeByScore implements ZREVRANGEBYSCORE with LIMIT offset count.
+// A negative count returns all the elements from offset on.
+func (z *SortedSet[K, S]) RevRangeByScore(r ScoreRange[S], offset, count int64, f func(S, K)) {
+	z.snapshotRangeByScore(r, offset, count, true, f)
+}
+
+func (z *SortedSet[K, S]) snapshotRangeByScore(r ScoreRange[S], offset, count int64, reverse bool, f func(S, K)) {
+	scores := make([]S, 0)
+	keys := make([]K, 0)
+
+	z.lock.RLock()
+	z.commonRangeByScore(r.spec(z.nan), offset, count, reverse, func(f S, k K) {
+		scores = append(scores, f)
+		keys = append(keys, k)
+	})
+	z.lock.RUnlock()
+
+	for i, score := range scores {
+		f(score, keys[i])
+	}
+}
+
+func (z *SortedSet[K, S]) commonRangeByScore(ran *zrangespec[S], offset, count int64, reverse bool, f func(S, K)) {
+	if offset < 0 {
+		return
+	}
+	var node *skipListNode[K, S]
+	if reverse {
+		node = z.zsl.zslLastInRange(ran)
+	} else {
+		node = z.zsl.zslFirstInRange(ran)
+	}
+	/* If there is an offset, just move element after element. */
+	for node != nil && offset > 0 {
+		offset--
+		if reverse {
+			node = node.backward
+		} else {
+			node = node.level[0].forward
+		}
+	}
+	for node != nil && count != 0 {
+		/* Abort when the node is no longer in range. */
+		if reverse {
+			if !zslValueGteMin(z.compareScore, node.score, ran) {
+				break
+			}
+		} else {
+			if !zslValueLteMax(z.compareScore, node.score, ran) {
+				break
+			}
+		}
+		count--
+		f(node.score, node.objID)
+		if reverse {
+			node = node.backward
+		} else {
+			node = node.level[0].forward
+		}
+	}
+}
+
+// Count implements ZCOUNT
+func (z *SortedSet[K, S]) Count(r ScoreRange[S]) int64 {
+	z.lock.RLock()
+	defer z.lock.RUnlock()
+	return z.count(r)
+}
+
+func (z *SortedSet[K, S]) count(r ScoreRange[S]) int64 {
+	ran := r.spec(z.nan)
+	zsl := z.zsl
+
+	/* Find first element in range */
+	zn := zsl.zslFirstInRange(ran)
+	if zn == nil {
+		return 0
+	}
+	rank := zsl.zslGetRank(zn.score, zn.objID)
+	count := zsl.length - (rank - 1)
+
+	/* Find last element in range */
+	zn = zsl.zslLastInRange(ran)
+	if zn != nil {
+		rank = zsl.zslGetRank(zn.score, zn.objID)
+		count -= zsl.length - rank
+	}
+	return count
+}
+
+// RankOfScore returns the rank an element with the given score would get,
+// without adding anything. Elements with an equal score are counted after
+// it, so in ascending order the result is the number of elements with a
+// lower score, and in descending order (reverse) with a higher score.
+func (z *SortedSet[K, S]) RankOfScore(score S, reverse bool) int64 {
+	z.lock.RLock()
+	defer z.lock.RUnlock()
+	return z.rankOfScore(score, reverse)
+}
+
+func (z *SortedSet[K, S]) rankOfScore(score S, reverse bool) int64 {
+	if reverse {
+		return z.zsl.length - int64(z.zsl.zslCountLess(score, true))
+	}
+	return int64(z.zsl.zslCountLess(score, false))
+}
+
+// ParseLexRange parses min and max the way ZRANGEBYLEX does:
+// "[" makes a bound inclusive, "(" exclusive, and "-" and "+"
+// are the smallest and the greatest possible strings.
+func ParseLexRange(min, max string) (LexRange[string], error) {
+	var r LexRange[string]
+	var minPlus, maxMinus bool
+	switch {
+	case min == "-":
+		r.MinInf = true
+	case min == "+":
+		minPlus = true
+	case strings.HasPrefix(min, "("):
+		r.Min, r.MinEx = min[1:], true
+	case strings.HasPrefix(min, "["):
+		r.Min = min[1:]
+	default:
+		return LexRange[string]{}, ErrNotLexRange
+	}
+	switch {
+	case max == "+":
+		r.MaxInf = true
+	case max == "-":
+		maxMinus = true
+	case strings.HasPrefix(max, "("):
+		r.Max, r.MaxEx = max[1:], true
+	case strings.HasPrefix(max, "["):
+		r.Max = max[1:]
+	default:
+		return LexRange[string]{}, ErrNotLexRange
+	}
+	if minPlus || maxMinus {
+		/* Nothing is greater than "+" or less than "-". */
+		return LexRange[string]{MinEx: true, MaxEx: true}, nil
+	}
+	return r, nil
+}
+
+func (r LexRange[K]) spec() *zlexrangespec[K] {
+	spec := &zlexrangespec[K]{minKey: r.Min, maxKey: r.Max}
+	if r.MinEx {
+		spec.minex = 1
+	}
+	if r.MaxEx {
+		spec.maxex = 1
+	}
+	if r.MinInf {
+		spec.mininf = 1
+	}
+	if r.MaxInf {
+		spec.maxinf = 1
+	}
+	return spec
+}
+
+// RangeByLex implements ZRANGEBYLEX with LIMIT offset count.
+// Like in Redis, it expects all the elements to have the same score.
+// A negative count returns all the elements from offset on.
+func (z *SortedSet[K, S]) RangeByLex(r LexRange[K], offset, count int64, f func(S, K)) {
+	z.snapshotRangeByLex(r, offset, count, false, f)
+}
+
+// RevRangeByLex implements ZREVRANGEBYLEX with LIMIT offset count.
+// A negative count returns all the elements from offset on.
+func (z *SortedSet[K, S]) RevRangeByLex(r LexRange[K], offset, count int64, f func(S, K)) {
+	z.snapshotRangeByLex(r, offset, count, true, f)
+}
+
+// LexCount implements ZLEXCOUNT
+func (z *SortedSet[K, S]) LexCount(r LexRange[K]) int64 {
+	z.lock.RLock()
+	defer z.lock.RUnlock()
+	return z.lexCount(r)
+}
+
+func (z *SortedSet[K, S]) lexCount(r LexRange[K]) int64 {
+	ran := r.spec()
+	zsl := z.zsl
+
+	/* Find first element in range */
+	zn := zsl.zslFirstInLexRange(ran)
+	if zn == nil {
+		return 0
+	}
+	rank := zsl.zslGetRank(zn.score, zn.objID)
+	count := zsl.length - (rank - 1)
+
+	/* Find last element in range */
+	zn = zsl.zslLastInLexRange(ran)
+	if zn != nil {
+		rank = zsl.zslGetRank(zn.score, zn.objID)
+		count -= zsl.length - rank
+	}
+	return count
+}
+
+func (z *SortedSet[K, S]) snapshotRangeByLex(r LexRange[K], offset, count int64, reverse bool, f func(S, K)) {
+	scores := make([]S, 0)
+	keys := make([]K, 0)
+
+	z.lock.RLock()
+	z.commonRangeByLex(r.spec(), offset, count, reverse, func(f S, k K) {
+		scores = append(scores, f)
+		keys = append(keys, k)
+	})
+	z.lock.RUnlock()
+
+	for i, score := range scores {
+		f(score, keys[i])
+	}
+}
+
+func (z *SortedSet[K, S]) commonRangeByLex(ran *zlexrangespec[K], offset, count int64, reverse bool, f func(S, K)) {
+	if offset < 0 {
+		return
+	}
+	var node *skipListNode[K, S]
+	if reverse {
+		node = z.zsl.zslLastInLexRange(ran)
+	} else {
+		node = z.zsl.zslFirstInLexRange(ran)
+	}
+	/* If there is an offset, just move element after element. */
+	for node != nil && offset > 0 {
+		offset--
+		if reverse {
+			node = node.backward
+		} else {
+			node = node.level[0].forward
+		}
+	}
+	for node != nil && count != 0 {
+		/* Abort when the node is no longer in range. */
+		if reverse {
+			if !zslLexValueGteMin(z.compare, node.objID, ran) {
+				break
+			}
+		} else {
+			if !zslLexValueLteMax(z.compare, node.objID, ran) {
+				break
+			}
+		}
+		count--
+		f(node.score, node.objID)
+		if reverse {
+			node = node.backward
+		} else {
+			node = node.level[0].forward
+		}
+	}
+}
+
+// RemoveRangeByScore implements ZREMRANGEBYSCORE and returns the number
+// of removed elements. If f is not nil, it is called with every removed
+// element in ascending order.
+func (z *SortedSet[K, S]) RemoveRangeByScore(r ScoreRange[S], f func(S, K)) int64 {
+	z.lock.Lock()
+	removed, members := z.removeRangeByScore(r, f != nil)
+	z.lock.Unlock()
+	return callRemoved(removed, members, f)
+}
+
+// RemoveRangeByLex implements ZREMRANGEBYLEX and returns the number
+// of removed elements. If f is not nil, it is called with every removed
+// element in ascending order. Like RangeByLex, it expects all the elements
+// to have the same score; otherwise which ones are in range is unspecified,
+// but f is still called with exactly the elements removed.
+func (z *SortedSet[K, S]) RemoveRangeByLex(r LexRange[K], f func(S, K)) int64 {
+	z.lock.Lock()
+	removed, members := z.removeRangeByLex(r, f != nil)
+	z.lock.Unlock()
+	return callRemoved(removed, members, f)
+}
+
+// RemoveRangeByRank implements ZREMRANGEBYRANK and returns the number
+// of removed elements. Like in Range, start and end are 0-based, inclusive
+// and may be negative to count from the highest score.
+// If f is not nil, it is called with every removed element in ascending order.
+func (z *SortedSet[K, S]) RemoveRangeByRank(start, end int64, f func(S, K)) int64 {
+	z.lock.Lock()
+	removed, members := z.removeRangeByRank(start, end, f != nil)
+	z.lock.Unlock()
+	return callRemoved(removed, members, f)
+}
+
+func callRemoved[K comparable, S any](removed int64, members []Member[K, S], f func(S, K)) int64 {
+	if f != nil {
+		for _, m := range members {
+			f(m.Score, m.Key)
+		}
+	}
+	return removed
+}
+
+/* The remove functions must be called with the write lock held. They return
+ * the removed elements too, if keep is true or there is an onRemove hook or
+ * a journal. */
+
+func (z *SortedSet[K, S]) removeRangeByScore(r ScoreRange[S], keep bool) (int64, []Member[K, S]) {
+	ran := r.spec(z.nan)
+	var members []Member[K, S]
+	if keep || z.onRemove != nil || z.journal != nil {
+		z.commonRangeByScore(ran, 0, -1, false, func(score S, k K) {
+			members = append(members, Member[K, S]{Key: k, Score: score})
+		})
+	}
+	removed := z.zsl.zslDeleteRangeByScore(ran, z.dict)
+	z.journalRemoved(members)
+	z.removed(members)
+	return int64(removed), members
+}
+
+func (z *SortedSet[K, S]) removeRangeByLex(r LexRange[K], keep bool) (int64, []Member[K, S]) {
+	ran := r.spec()
+	var (
+		members []Member[K, S]
+		first   Member[K, S]
+		n       int64
+	)
+	/* With mixed scores, which nodes a lex range walk finds depends on the
+	 * levels of the nodes, so the nodes deleted are the ones walked. */
+	z.commonRangeByLex(ran, 0, -1, false, func(score S, k K) {
+		if n == 0 {
+			first = Member[K, S]{Key: k, Score: score}
+		}
+		n++
+		if keep || z.onRemove != nil || z.journal != nil {
+			members = append(members, Member[K, S]{Key: k, Score: score})
+		}
+	})
+	removed := z.deleteRun(first, n)
+	z.journalRemoved(members)
+	z.removed(members)
+	return int64(removed), members
+}
+
+/* Deletes the n consecutive elements from first on, which the caller found
+ * walking the skiplist, so that exactly them are deleted. */
+func (z *SortedSet[K, S]) deleteRun(first Member[K, S], n int64) uint64 {
+	if n == 0 {
+		return 0
+	}
+	rank := uint64(z.zsl.zslGetRank(first.Score, first.Key))
+	return z.zsl.zslDeleteRangeByRank(rank, rank+uint64(n)-1, z.dict)
+}
+
+func (z *SortedSet[K, S]) removeRangeByRank(start, end int64, keep bool) (int64, []Member[K, S]) {
+	/* Sanitize indexes. */
+	l := z.zsl.length
+	if start < 0 {
+		start += l
+	}
+	if end < 0 {
+		end += l
+	}
+	if start < 0 {
+		start = 0
+	}
+	/* Invariant: start >= 0, so this test will be true when end < 0.
+	 * The range is empty when start > end or start >= length. */
+	if start > end || start >= l {
+		return 0, nil
+	}
+	if end >= l {
+		end = l - 1
+	}
+	var members []Member[K, S]
+	if keep || z.onRemove != nil || z.journal != nil {
+		z.commonRange(start, end, false, func(score S, k K) {
+			members = append(members, Member[K, S]{Key: k, Score: score})
+		})
+	}
+	/* Correct for 1-based rank. */
+	removed := z.zsl.zslDeleteRangeByRank(uint64(start+1), uint64(end+1), z.dict)
+	z.journalRemoved(members)
+	z.removed(members)
+	return int64(removed), members
+}
+
+// PopMin implements ZPOPMIN, it removes and returns up to count elements
+// with the lowest scores, in ascending order.
+func (z *SortedSet[K, S]) PopMin(count int64) []Member[K, S] {
+	z.lock.Lock()
+	defer z.lock.Unlock()
+	return z.pop(count, false)
+}
+
+// PopMax implements ZPOPMAX, it removes and returns up to count elements
+// with the highest scores, in descending order.
+func (z *SortedSet[K, S]) PopMax(count int64) []Member[K, S] {
+	z.lock.Lock()
+	defer z.lock.Unlock()
+	return z.pop(count, true)
+}
+
+// PopOne removes and returns the element with the lowest score, or with
+// the highest one if reverse is true. It returns ErrEmpty if there is none.
+func (z *SortedSet[K, S]) PopOne(reverse bool) (key K, score S, err error) {
+	z.lock.Lock()
+	defer z.lock.Unlock()
+	members := z.pop(1, reverse)
+	if len(members) == 0 {
+		return key, score, ErrEmpty
+	}
+	return members[0].Key, members[0].Score, nil
+}
+
+// MPop implements ZMPOP, it pops up to count elements from the first
+// non-empty set, PopMax-style when reverse is true and PopMin-style
+// otherwise. It returns the index of the set popped from in sets,
+// or -1 when all of them are empty.
+func MPop[K comparable, S any](count int64, reverse bool, sets ...*SortedSet[K, S]) (int, []Member[K, S]) {
+	if count <= 0 {
+		return -1, nil
+	}
+	for i, z := range sets {
+		z.lock.Lock()
+		members := z.pop(count, reverse)
+		z.lock.Unlock()
+		if len(members) > 0 {
+			return i, members
+		}
+	}
+	return -1, nil
+}
+
+func (z *SortedSet[K, S]) pop(count int64, reverse bool) []Member[K, S] {
+	l := z.zsl.length
+	if count <= 0 || l == 0 {
+		return nil
+	}
+	if count > l {
+		count = l
+	}
+	members := make([]Member[K, S], 0, count)
+	z.commonRange(0, count-1, reverse, func(score S, k K) {
+		members = append(members, Member[K, S]{Key: k, Score: score})
+	})
+	if reverse {
+		z.zsl.zslDeleteRangeByRank(uint64(l-count+1), uint64(l), z.dict)
+	} else {
+		z.zsl.zslDeleteRangeByRank(1, uint64(count), z.dict)
+	}
+	z.journalPop(count, reverse)
+	z.removed(members)
+	return members
+}
+
+/* Must be called with the write lock held, after members are removed. */
+func (z *SortedSet[K, S]) removed(members []Member[K, S]) {
+	if z.onRemove == nil {
+		return
+	}
+	for _, m := range members {
+		z.onRemove(m.Key)
+	}
+}
+
+/* How many times bigger should the set be compared to the requested size
+ * for us to not use the "remove elements" strategy? Read later in the
+ * implementation for more info. */
+const zrandmemberSubStrategyMul = 3
+
+// RandMember implements ZRANDMEMBER with a count. A positive count returns
+// up to count distinct elements, a negative one exactly -count elements
+// which may repeat. Every pick is a rank lookup, so it costs O(log(N)).
+// Random numbers come from r, or from the default source if r is nil.
+// A count out of ±math.MaxInt64/2 is rejected with ErrCountOutOfRange.
+func (z *SortedSet[K, S]) RandMember(count int64, r *rand.Rand) ([]Member[K, S], error) {
+	z.lock.RLock()
+	defer z.lock.RUnlock()
+	return z.randMember(count, r)
+}
+
+func (z *SortedSet[K, S]) randMember(count int64, r *rand.Rand) ([]Member[K, S], error) {
+	/* Also makes sure -count can be computed. */
+	if count < -math.MaxInt64/2 || count > math.MaxInt64/2 {
+		return nil, ErrCountOutOfRange
+	}
+	randn := rand.Int63n
+	if r != nil {
+		randn = r.Int63n
+	}
+	size := z.zsl.length
+	if count == 0 || size == 0 {
+		return nil, nil
+	}
+	pick := func() Member[K, S] {
+		n := z.zsl.zslGetElementByRank(uint64(randn(size)) + 1)
+		return Member[K, S]{Key: n.objID, Score: n.score}
+	}
+
+	/* CASE 1: The count was negative, so the extraction method is just:
+	 * "return N random elements" sampling the whole set every time.
+	 * This case is trivial and can be served without auxiliary data
+	 * structures. */
+	if count < 0 {
+		members := make([]Member[K, S], -count)
+		for i := range members {
+			members[i] = pick()
+		}
+		return members, nil
+	}
+
+	/* CASE 2:
+	 * The number of requested elements is greater than the number of
+	 * elements inside the zset: simply return the whole zset. */
+	if count >= size {
+		members := make([]Member[K, S], 0, size)
+		z.commonRange(0, -1, false, func(score S, k K) {
+			members = append(members, Member[K, S]{Key: k, Score: score})
+		})
+		return members, nil
+	}
+
+	/* CASE 3:
+	 * The number of elements inside the zset is not greater than
+	 * zrandmemberSubStrategyMul times the number of requested elements.
+	 * In this case we create a copy of the zset, and subtract random
+	 * elements to reach the requested number of elements.
+	 *
+	 * This is done because if the number of requested elements is just
+	 * a bit less than the number of elements in the set, the natural approach
+	 * used into CASE 4 is highly inefficient. */
+	if count*zrandmemberSubStrategyMul > size {
+		members := make([]Member[K, S], 0, size)
+		z.commonRange(0, -1, false, func(score S, k K) {
+			members = append(members, Member[K, S]{Key: k, Score: score})
+		})
+		/* Remove random elements to reach the right count. */
+		for int64(len(members)) > count {
+			i := randn(int64(len(members)))
+			last := len(members) - 1
+			members[i] = members[last]
+			members = members[:last]
+		}
+		return members, nil
+	}
+
+	/* CASE 4: We have a big zset compared to the requested number of
+	 * elements. In this case we can simply get random elements from the
+	 * zset and add to the temporary set, trying to eventually get enough
+	 * unique elements to reach the specified count. */
+	members := make([]Member[K, S], 0, count)
+	seen := make(map[K]struct{}, count)
+	for int64(len(members)) < count {
+		m := pick()
+		if _, ok := seen[m.Key]; ok {
+			continue
+		}
+		seen[m.Key] = struct{}{}
+		members = append(members, m)
+	}
+	return members, nil
+}
@@ -1,11 +1,14 @@
 package zset
 
 import (
+	"fmt"
+	"math"
 	"math/rand"
+	"strings"
 	"testing"
 )
 
-var s *SortedSet[int64]
+var s *SortedSet[int64, float64]
 
 func init() {
 	s = New[int64]()
@@ -54,8 +57,8 @@ func TestNew(t *testing.T) {
 	if s.Length() != 5 {
 		t.Error("Rank Data Size is wrong")
 	}
-	curScore := s.IncrBy(666, 1004)
-	t.Log(curScore)
+	curScore, err := s.IncrBy(666, 1004)
+	t.Log(curScore, err)
 }
 
 func TestIncrBy(t *testing.T) {
@@ -64,9 +67,9 @@ func TestIncrBy(t *testing.T) {
 		z.Set(float64(i), int64(i))
 	}
 	rank, score := z.GetRank(1050, false)
-	curScore := z.IncrBy(1.5, 1050)
-	if score+1.5 != curScore {
-		t.Error(score, curScore)
+	curScore, err := z.IncrBy(1.5, 1050)
+	if score+1.5 != curScore || err != nil {
+		t.Error(score, curScore, err)
 	}
 	r2, score2 := z.GetRank(1050, false)
 	if score2 != curScore {
@@ -78,6 +81,354 @@ func TestIncrBy(t *testing.T) {
 
 }
 
+func TestIncrByNew(t *testing.T) {
+	z := New[int64]()
+	if score, err := z.IncrBy(2.5, 1001); score != 2.5 || err != nil {
+		t.Error(score, err)
+	}
+	if score, ok := z.GetScore(1001); !ok || score != 2.5 {
+		t.Error(score, ok)
+	}
+}
+
+func TestIntegerScores(t *testing.T) {
+	z := NewOf[string, int64]()
+	big := int64(1)<<53 + 1
+	z.Set(big, "a")
+	z.Set(big+1, "b")
+	z.Set(big-1, "c")
+	order := ""
+	z.Range(0, -1, func(score int64, k string) {
+		order += k
+	})
+	if order != "cab" {
+		t.Error(order)
+	}
+	if score, err := z.IncrBy(1, "a"); score != big+1 || err != nil {
+		t.Error(score, err)
+	}
+	if n := z.Count(ScoreRange[int64]{Min: big, Max: big + 1}); n != 2 {
+		t.Error(n)
+	}
+
+	z.Set(math.MaxInt64-1, "max")
+	if score, err := z.IncrBy(2, "max"); score != math.MaxInt64-1 || err != ErrOverflow {
+		t.Error(score, err)
+	}
+	if score, _, err := z.Add(1, "max", INCR); score != math.MaxInt64 || err != nil {
+		t.Error(score, err)
+	}
+	z.Set(math.MinInt64, "min")
+	if score, err := z.IncrBy(-1, "min"); score != math.MinInt64 || err != ErrOverflow {
+		t.Error(score, err)
+	}
+	if rank, score := z.GetRank("min", false); rank != 0 || score != math.MinInt64 {
+		t.Error(rank, score)
+	}
+
+	u := NewOf[int, uint8]()
+	u.Set(250, 1)
+	if score, err := u.IncrBy(6, 1); score != 250 || err != ErrOverflow {
+		t.Error(score, err)
+	}
+	if score, err := u.IncrBy(5, 1); score != 255 || err != nil {
+		t.Error(score, err)
+	}
+
+	f := New[string]()
+	f.Set(math.Inf(1), "inf")
+	if score, err := f.IncrBy(math.Inf(-1), "inf"); !math.IsInf(score, 1) || err != ErrNaN {
+		t.Error(score, err)
+	}
+}
+
+func TestAdd(t *testing.T) {
+	z := New[string]()
+	cases := []struct {
+		score  float64
+		key    string
+		flags  AddFlag
+		want   float64
+		result AddResult
+		err    error
+	}{
+		{10, "a", 0, 10, Added, nil},
+		{10, "a", 0, 10, Unchanged, nil},
+		{20, "a", NX, 10, Skipped, nil},
+		{20, "b", XX, 0, Skipped, nil},
+		{5, "a", GT, 10, Skipped, nil},
+		{15, "a", GT, 15, Updated, nil},
+		{15, "a", LT, 15, Skipped, nil},
+		{12, "a", LT | XX, 12, Updated, nil},
+		{3, "a", INCR, 15, Updated, nil},
+		{-1, "a", INCR | GT, 15, Skipped, nil},
+		{7, "c", INCR | NX, 7, Added, nil},
+		{1, "c", NX | XX, 0, Skipped, ErrAddFlags},
+		{1, "c", GT | LT, 0, Skipped, ErrAddFlags},
+		{1, "c", NX | GT, 0, Skipped, ErrAddFlags},
+		{math.NaN(), "c", 0, 0, Skipped, ErrNaN},
+		{math.Inf(1), "d", 0, math.Inf(1), Added, nil},
+		{math.Inf(-1), "d", INCR, math.Inf(1), Skipped, ErrNaN},
+	}
+	for i, c := range cases {
+		score, result, err := z.Add(c.score, c.key, c.flags)
+		if score != c.want || result != c.result || err != c.err {
+			t.Error(i, score, result, err)
+		}
+	}
+	order := ""
+	z.Range(0, -1, func(score float64, k string) {
+		order += k
+	})
+	if order != "cad" {
+		t.Error(order)
+	}
+}
+
+func TestNaNAndInf(t *testing.T) {
+	z := New[string]()
+	if err := z.Set(math.NaN(), "nan"); err != ErrNaN || z.Length() != 0 {
+		t.Error(err, z.Length())
+	}
+	inf, ninf := math.Inf(1), math.Inf(-1)
+	z.Set(inf, "b")
+	z.Set(0, "c")
+	z.Set(ninf, "d")
+	z.Set(inf, "a")
+	z.Set(ninf, "e")
+	order := ""
+	z.Range(0, -1, func(score float64, k string) {
+		order += k
+	})
+	if order != "decab" {
+		t.Error(order)
+	}
+	if _, err := z.IncrBy(ninf, "a"); err != ErrNaN {
+		t.Error(err)
+	}
+	if _, err := z.IncrBy(math.NaN(), "c"); err != ErrNaN {
+		t.Error(err)
+	}
+	if score, _ := z.GetScore("a"); score != inf {
+		t.Error(score)
+	}
+
+	for _, c := range []struct {
+		min, max string
+		count    int64
+	}{
+		{"-inf", "+inf", 5},
+		{"(-inf", "+inf", 3},
+		{"(-inf", "(+inf", 1},
+		{"-inf", "(-inf", 0},
+		{"+inf", "+inf", 2},
+		{"(+inf", "+inf", 0},
+		{"-inf", "-inf", 2},
+	} {
+		r, err := ParseScoreRange(c.min, c.max)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := z.Count(r); n != c.count {
+			t.Error(c.min, c.max, n)
+		}
+	}
+	if _, err := ParseScoreRange("nan", "1"); err != ErrNotFloat {
+		t.Error(err)
+	}
+	nan := ScoreRange[float64]{Min: math.NaN(), Max: inf}
+	if n := z.Count(nan); n != 0 {
+		t.Error(n)
+	}
+	z.RangeByScore(nan, 0, -1, func(score float64, k string) {
+		t.Error(k)
+	})
+	if n := z.RemoveRangeByScore(nan, nil); n != 0 {
+		t.Error(n)
+	}
+	if rank := z.RankOfScore(inf, false); rank != 3 {
+		t.Error(rank)
+	}
+	if rank := z.RankOfScore(inf, true); rank != 0 {
+		t.Error(rank)
+	}
+
+	r, _ := ParseScoreRange("+inf", "+inf")
+	if n := z.RemoveRangeByScore(r, nil); n != 2 || z.Length() != 3 {
+		t.Error(n, z.Length())
+	}
+	if !z.Delete("d") || !z.Delete("e") || z.Length() != 1 {
+		t.Error(z.Length())
+	}
+}
+
+func TestLookup(t *testing.T) {
+	z := NewOf[int64, int64]()
+	z.Set(0, 0)
+	z.Set(5, 7)
+	if key, score, err := z.LookupByRank(0, false); key != 0 || score != 0 || err != nil {
+		t.Error(key, score, err)
+	}
+	for _, rank := range []int64{-1, 2} {
+		for _, reverse := range []bool{false, true} {
+			if _, _, err := z.LookupByRank(rank, reverse); err != ErrOutOfRange {
+				t.Error(rank, reverse, err)
+			}
+		}
+	}
+	if rank, score, err := z.LookupRank(0, true); rank != 1 || score != 0 || err != nil {
+		t.Error(rank, score, err)
+	}
+	if rank, _, err := z.LookupRank(1, false); rank != -1 || err != ErrNotFound {
+		t.Error(rank, err)
+	}
+	if score, err := z.LookupScore(7); score != 5 || err != nil {
+		t.Error(score, err)
+	}
+	if _, err := z.LookupScore(1); err != ErrNotFound {
+		t.Error(err)
+	}
+
+	if key, score, err := z.PopOne(true); key != 7 || score != 5 || err != nil {
+		t.Error(key, score, err)
+	}
+	if key, score, err := z.PopOne(false); key != 0 || score != 0 || err != nil {
+		t.Error(key, score, err)
+	}
+	if _, _, err := z.PopOne(false); err != ErrEmpty {
+		t.Error(err)
+	}
+}
+
+func TestSetMany(t *testing.T) {
+	z := New[string]()
+	z.Set(1, "a")
+	z.Set(2, "b")
+	results, err := z.SetMany([]Member[string, float64]{
+		{Key: "a", Score: 1},
+		{Key: "b", Score: 5},
+		{Key: "c", Score: 3},
+		{Key: "c", Score: 4},
+	})
+	want := []AddResult{Unchanged, Updated, Added, Updated}
+	if err != nil || len(results) != len(want) {
+		t.Fatal(results, err)
+	}
+	for i := range want {
+		if results[i] != want[i] {
+			t.Error(i, results[i])
+		}
+	}
+	if rank, _ := z.GetRank("c", false); rank != 1 || z.Length() != 3 {
+		t.Error(rank, z.Length())
+	}
+	if _, err := z.SetMany([]Member[string, float64]{{"d", 1}, {"e", math.NaN()}}); err != ErrNaN || z.Length() != 3 {
+		t.Error(err, z.Length())
+	}
+
+	scores, err := z.IncrByMany([]Member[string, float64]{{"a", 1}, {"d", 2}, {"a", math.Inf(1)}, {"a", math.Inf(-1)}, {"c", 1}})
+	if err != ErrNaN || len(scores) != 3 || scores[0] != 2 || scores[1] != 2 || !math.IsInf(scores[2], 1) {
+		t.Error(scores, err)
+	}
+	if score, _ := z.GetScore("c"); score != 4 {
+		t.Error(score)
+	}
+
+	deleted := z.DeleteMany([]string{"a", "x", "d", "a"})
+	if !deleted[0] || deleted[1] || !deleted[2] || deleted[3] || z.Length() != 2 {
+		t.Error(deleted, z.Length())
+	}
+}
+
+func TestPop(t *testing.T) {
+	z := New[string]()
+	for i, k := range []string{"a", "b", "c", "d", "e"} {
+		z.Set(float64(i), k)
+	}
+	keys := func(members []Member[string, float64]) string {
+		all := ""
+		for _, m := range members {
+			all += m.Key
+		}
+		return all
+	}
+	if m := z.PopMin(2); keys(m) != "ab" || m[1].Score != 1 {
+		t.Error(m)
+	}
+	if m := z.PopMax(1); keys(m) != "e" || m[0].Score != 4 {
+		t.Error(m)
+	}
+	if m := z.PopMin(0); len(m) != 0 {
+		t.Error(m)
+	}
+	if m := z.PopMax(10); keys(m) != "dc" {
+		t.Error(m)
+	}
+	if z.Length() != 0 || len(z.PopMin(1)) != 0 {
+		t.Error(z.Length())
+	}
+	if _, ok := z.GetScore("c"); ok {
+		t.Error("c should be removed from dict")
+	}
+
+	empty := New[string]()
+	other := New[string]()
+	other.Set(1, "x")
+	other.Set(2, "y")
+	other.Set(3, "z")
+	if i, m := MPop(2, true, empty, other, z); i != 1 || keys(m) != "zy" {
+		t.Error(i, m)
+	}
+	if i, m := MPop(2, false, empty, z); i != -1 || m != nil {
+		t.Error(i, m)
+	}
+}
+
+func TestRandMember(t *testing.T) {
+	z := New[int64]()
+	if m, err := z.RandMember(5, nil); len(m) != 0 || err != nil {
+		t.Error(m, err)
+	}
+	for i := int64(0); i < 100; i++ {
+		z.Set(float64(i), i)
+	}
+	r := rand.New(rand.NewSource(1))
+	for _, count := range []int64{1, 10, 40, 99, 100, 200} {
+		m, _ := z.RandMember(count, r)
+		want := count
+		if want > 100 {
+			want = 100
+		}
+		if int64(len(m)) != want {
+			t.Error(count, len(m))
+		}
+		seen := make(map[int64]bool)
+		for _, member := range m {
+			if seen[member.Key] || member.Score != float64(member.Key) {
+				t.Error(count, member)
+			}
+			seen[member.Key] = true
+		}
+	}
+	if m, _ := z.RandMember(-300, r); len(m) != 300 {
+		t.Error(len(m))
+	}
+	for _, count := range []int64{math.MinInt64, -math.MaxInt64/2 - 1, math.MaxInt64/2 + 1} {
+		if m, err := z.RandMember(count, r); m != nil || err != ErrCountOutOfRange {
+			t.Error(count, len(m), err)
+		}
+	}
+
+	a, _ := z.RandMember(-20, rand.New(rand.NewSource(42)))
+	b, _ := z.RandMember(-20, rand.New(rand.NewSource(42)))
+	for i := range a {
+		if a[i] != b[i] {
+			t.Error("same seed, different members", a, b)
+			break
+		}
+	}
+}
+
 func TestRange(t *testing.T) {
 	z := New[int64]()
 	z.Set(1.0, 1001)
@@ -104,6 +455,395 @@ func TestRange(t *testing.T) {
 
 }
 
+func TestRangeByScore(t *testing.T) {
+	z := New[int64]()
+	for i := int64(1); i <= 10; i++ {
+		z.Set(float64(i), 1000+i)
+	}
+	collect := func(rangeFn func(ScoreRange[float64], int64, int64, func(float64, int64)), r ScoreRange[float64], offset, count int64) []int64 {
+		ids := make([]int64, 0)
+		rangeFn(r, offset, count, func(score float64, k int64) {
+			ids = append(ids, k)
+		})
+		return ids
+	}
+	equal := func(a, b []int64) bool {
+		if len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if a[i] != b[i] {
+				return false
+			}
+		}
+		return true
+	}
+
+	cases := []struct {
+		min, max      string
+		offset, count int64
+		asc, desc     []int64
+	}{
+		{"2", "4", 0, -1, []int64{1002, 1003, 1004}, []int64{1004, 1003, 1002}},
+		{"(2", "(4", 0, -1, []int64{1003}, []int64{1003}},
+		{"-inf", "+inf", 8, -1, []int64{1009, 1010}, []int64{1002, 1001}},
+		{"-inf", "(3", 0, -1, []int64{1001, 1002}, []int64{1002, 1001}},
+		{"3", "+inf", 1, 2, []int64{1004, 1005}, []int64{1009, 1008}},
+		{"5", "5", 0, -1, []int64{1005}, []int64{1005}},
+		{"(5", "5", 0, -1, []int64{}, []int64{}},
+		{"6", "5", 0, -1, []int64{}, []int64{}},
+		{"1", "10", -1, -1, []int64{}, []int64{}},
+		{"1", "10", 0, 0, []int64{}, []int64{}},
+	}
+	for _, c := range cases {
+		r, err := ParseScoreRange(c.min, c.max)
+		if err != nil {
+			t.Fatal(c.min, c.max, err)
+		}
+		if ids := collect(z.RangeByScore, r, c.offset, c.count); !equal(ids, c.asc) {
+			t.Error(c.min, c.max, c.offset, c.count, ids)
+		}
+		if ids := collect(z.RevRangeByScore, r, c.offset, c.count); !equal(ids, c.desc) {
+			t.Error(c.min, c.max, c.offset, c.count, ids)
+		}
+	}
+
+	for _, bad := range [][2]string{{"a", "1"}, {"1", "(b"}, {"nan", "1"}} {
+		if _, err := ParseScoreRange(bad[0], bad[1]); err != ErrNotFloat {
+			t.Error(bad, err)
+		}
+	}
+}
+
+func TestCount(t *testing.T) {
+	z := New[int64]()
+	for i := int64(1); i <= 10; i++ {
+		z.Set(float64(i), 1000+i)
+	}
+	z.Set(5, 2005)
+
+	for _, c := range []struct {
+		min, max string
+		n        int64
+	}{
+		{"-inf", "+inf", 11},
+		{"5", "5", 2},
+		{"(5", "7", 2},
+		{"3", "(5", 2},
+		{"(1", "(2", 0},
+		{"11", "+inf", 0},
+		{"7", "3", 0},
+	} {
+		r, _ := ParseScoreRange(c.min, c.max)
+		if n := z.Count(r); n != c.n {
+			t.Error(c.min, c.max, n)
+		}
+	}
+
+	for _, c := range []struct {
+		score     float64
+		asc, desc int64
+	}{
+		{0, 0, 11},
+		{1, 0, 10},
+		{5, 4, 5},
+		{5.5, 6, 5},
+		{10, 10, 0},
+		{math.Inf(1), 11, 0},
+	} {
+		if r := z.RankOfScore(c.score, false); r != c.asc {
+			t.Error(c.score, r)
+		}
+		if r := z.RankOfScore(c.score, true); r != c.desc {
+			t.Error(c.score, r)
+		}
+	}
+}
+
+func TestRangeByLex(t *testing.T) {
+	z := New[string]()
+	for _, k := range []string{"a", "b", "c", "d", "e", "f", "g"} {
+		z.Set(0, k)
+	}
+	collect := func(rangeFn func(LexRange[string], int64, int64, func(float64, string)), r LexRange[string], offset, count int64) string {
+		keys := ""
+		rangeFn(r, offset, count, func(score float64, k string) {
+			keys += k
+		})
+		return keys
+	}
+
+	cases := []struct {
+		min, max      string
+		offset, count int64
+		asc, desc     string
+		n             int64
+	}{
+		{"-", "[c", 0, -1, "abc", "cba", 3},
+		{"-", "(c", 0, -1, "ab", "ba", 2},
+		{"[aaa", "(g", 0, -1, "bcdef", "fedcb", 5},
+		{"-", "+", 2, 3, "cde", "edc", 7},
+		{"(a", "+", 0, -1, "bcdefg", "gfedcb", 6},
+		{"[c", "[c", 0, -1, "c", "c", 1},
+		{"(c", "[c", 0, -1, "", "", 0},
+		{"[d", "[c", 0, -1, "", "", 0},
+		{"+", "+", 0, -1, "", "", 0},
+		{"-", "-", 0, -1, "", "", 0},
+		{"[h", "+", 0, -1, "", "", 0},
+	}
+	for _, c := range cases {
+		r, err := ParseLexRange(c.min, c.max)
+		if err != nil {
+			t.Fatal(c.min, c.max, err)
+		}
+		if keys := collect(z.RangeByLex, r, c.offset, c.count); keys != c.asc {
+			t.Error(c.min, c.max, c.offset, c.count, keys)
+		}
+		if keys := collect(z.RevRangeByLex, r, c.offset, c.count); keys != c.desc {
+			t.Error(c.min, c.max, c.offset, c.count, keys)
+		}
+		if n := z.LexCount(r); n != c.n {
+			t.Error(c.min, c.max, n)
+		}
+	}
+
+	for _, bad := range [][2]string{{"a", "+"}, {"-", "c"}, {"", "+"}} {
+		if _, err := ParseLexRange(bad[0], bad[1]); err != ErrNotLexRange {
+			t.Error(bad, err)
+		}
+	}
+}
+
+func TestRemoveRange(t *testing.T) {
+	fill := func() *SortedSet[string, float64] {
+		z := New[string]()
+		for i, k := range []string{"a", "b", "c", "d", "e", "f", "g"} {
+			z.Set(float64(i), k)
+		}
+		return z
+	}
+	keys := func(z *SortedSet[string, float64]) string {
+		all := ""
+		z.Range(0, -1, func(score float64, k string) {
+			all += k
+		})
+		return all
+	}
+
+	z := fill()
+	removed := ""
+	r, _ := ParseScoreRange("(1", "3")
+	if n := z.RemoveRangeByScore(r, func(score float64, k string) {
+		removed += k
+	}); n != 2 || removed != "cd" || keys(z) != "abefg" {
+		t.Error(n, removed, keys(z))
+	}
+	if _, ok := z.GetScore("c"); ok {
+		t.Error("c should be removed from dict")
+	}
+
+	z = fill()
+	for _, c := range []struct {
+		start, end int64
+		n          int64
+		left       string
+	}{
+		{5, 2, 0, "abcdefg"},
+		{7, 10, 0, "abcdefg"},
+		{-2, -1, 2, "abcde"},
+		{-100, 0, 1, "bcde"},
+		{1, 100, 3, "b"},
+	} {
+		if n := z.RemoveRangeByRank(c.start, c.end, nil); n != c.n || keys(z) != c.left {
+			t.Error(c.start, c.end, n, keys(z))
+		}
+	}
+	if z.Length() != 1 {
+		t.Error(z.Length())
+	}
+
+	z = New[string]()
+	for _, k := range []string{"a", "b", "c", "d", "e", "f", "g"} {
+		z.Set(0, k)
+	}
+	removed = ""
+	lr, _ := ParseLexRange("[b", "(e")
+	if n := z.RemoveRangeByLex(lr, func(score float64, k string) {
+		removed += k
+	}); n != 3 || removed != "bcd" || keys(z) != "aefg" {
+		t.Error(n, removed, keys(z))
+	}
+}
+
+func TestRemoveRangeByLexMixedScores(t *testing.T) {
+	lr, _ := ParseLexRange("[k3", "(k7")
+	for i := 0; i < 50; i++ {
+		z := New[string]()
+		for j := 0; j < 10; j++ {
+			z.Set(float64((j*7)%10), fmt.Sprintf("k%d", j))
+		}
+		/* The tail is out of range, the nodes before are not. */
+		z.Set(10, "a")
+		var removed []string
+		n := z.RemoveRangeByLex(lr, func(score float64, k string) {
+			removed = append(removed, k)
+		})
+		if n != int64(len(removed)) || z.Length() != 11-n {
+			t.Fatal(n, removed, z.Length())
+		}
+		for _, k := range removed {
+			if _, ok := z.GetScore(k); ok {
+				t.Fatal(k, "not removed")
+			}
+		}
+	}
+}
+
+func TestNewFunc(t *testing.T) {
+	type user struct {
+		tenant string
+		id     int
+	}
+	compare := func(a, b user) int {
+		if a.tenant != b.tenant {
+			return strings.Compare(a.tenant, b.tenant)
+		}
+		return a.id - b.id
+	}
+	z := NewFunc(compare)
+	z.Set(1, user{"b", 1})
+	z.Set(1, user{"a", 2})
+	z.Set(1, user{"a", 1})
+	z.Set(0, user{"c", 0})
+
+	got := make([]user, 0)
+	z.Range(0, -1, func(score float64, k user) {
+		got = append(got, k)
+	})
+	want := []user{{"c", 0}, {"a", 1}, {"a", 2}, {"b", 1}}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatal(got)
+		}
+	}
+	if rank, _ := z.GetRank(user{"a", 2}, false); rank != 2 {
+		t.Error(rank)
+	}
+	if !z.Delete(user{"a", 1}) || !z.Delete(user{"c", 0}) || z.Length() != 2 {
+		t.Error(z.Length())
+	}
+	n := z.LexCount(LexRange[user]{Min: user{"a", 0}, Max: user{"b", 0}})
+	if n != 1 {
+		t.Error(n)
+	}
+
+	/* Reversed order for the ties. */
+	desc := NewFunc(func(a, b string) int { return strings.Compare(b, a) })
+	for _, k := range []string{"a", "b", "c"} {
+		desc.Set(0, k)
+	}
+	order := ""
+	desc.Range(0, -1, func(score float64, k string) {
+		order += k
+	})
+	if order != "cba" {
+		t.Error(order)
+	}
+	lr, _ := ParseLexRange("[c", "(a")
+	if n := desc.RemoveRangeByLex(lr, nil); n != 2 || desc.Length() != 1 {
+		t.Error(n, desc.Length())
+	}
+}
+
+// result ranks by points desc, then by time asc, then by submission asc.
+type result struct {
+	points    int
+	seconds   int
+	submitted int64
+}
+
+func (r result) Compare(o result) int {
+	switch {
+	case r.points != o.points:
+		return o.points - r.points
+	case r.seconds != o.seconds:
+		return r.seconds - o.seconds
+	case r.submitted < o.submitted:
+		return -1
+	case r.submitted > o.submitted:
+		return 1
+	}
+	return 0
+}
+
+func TestNewComposite(t *testing.T) {
+	z := NewComposite[string, result]()
+	z.Set(result{100, 60, 3}, "a")
+	z.Set(result{100, 50, 4}, "b")
+	z.Set(result{90, 10, 1}, "c")
+	z.Set(result{100, 60, 2}, "d")
+
+	order := ""
+	z.Range(0, -1, func(score result, k string) {
+		order += k
+	})
+	if order != "bdac" {
+		t.Error(order)
+	}
+	if rank, _ := z.GetRank("a", false); rank != 2 {
+		t.Error(rank)
+	}
+	/* Equal scores tie on the key. */
+	z.Set(result{100, 60, 2}, "a")
+	if rank, _ := z.GetRank("a", false); rank != 1 {
+		t.Error(rank)
+	}
+	_, res, err := z.Add(result{110, 0, 0}, "c", GT)
+	if res != Skipped || err != nil {
+		t.Error(res, err)
+	}
+	_, res, _ = z.Add(result{80, 0, 0}, "c", GT)
+	if res != Updated {
+		t.Error(res)
+	}
+	if _, err := z.IncrBy(result{}, "c"); err != ErrNotIncrementable {
+		t.Error(err)
+	}
+
+	r := ScoreRange[result]{Min: result{100, 60, 0}, Max: result{90, 0, 0}}
+	order = ""
+	z.RangeByScore(r, 0, -1, func(score result, k string) {
+		order += k
+	})
+	if order != "ad" || z.Count(r) != 2 {
+		t.Error(order, z.Count(r))
+	}
+	if n := z.RemoveRangeByScore(r, nil); n != 2 || z.Length() != 2 {
+		t.Error(n, z.Length())
+	}
+
+	/* Fixed-size arrays work through NewWith. */
+	pairs := NewWith(func(a, b [2]int) int {
+		if a[0] != b[0] {
+			return a[0] - b[0]
+		}
+		return a[1] - b[1]
+	}, strings.Compare)
+	pairs.Set([2]int{1, 2}, "x")
+	pairs.Set([2]int{1, 1}, "y")
+	pairs.Set([2]int{0, 9}, "z")
+	if k, _ := pairs.GetDataByRank(0, true); k != "x" {
+		t.Error(k)
+	}
+	if d := Diff(pairs, pairs); d.Length() != 0 {
+		t.Error(d.Length())
+	}
+	d := Diff(pairs)
+	if k, _ := d.GetDataByRank(0, false); k != "z" {
+		t.Error(k)
+	}
+}
+
 func BenchmarkSortedSet_Add(b *testing.B) {
 	b.StopTimer()
 	// data initialization
@@ -123,6 +863,21 @@ func BenchmarkSortedSet_Add(b *testing.B) {
 	}
 }
 
+func BenchmarkSortedSet_SetMany(b *testing.B) {
+	b.StopTimer()
+	members := make([]Member[int64, float64], b.N)
+	for i := range members {
+		members[i] = Member[int64, float64]{
+			Key:   int64(i) + 100000,
+			Score: rand.Float64() + float64(rand.Int31n(99)),
+		}
+	}
+	z := New[int64]()
+
+	b.StartTimer()
+	z.SetMany(members)
+}
+
 func BenchmarkSortedSet_GetRank(b *testing.B) {
 	l := s.Length()
 	for i := 0; i < b.N; i++ {